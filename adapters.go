@@ -0,0 +1,140 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"github.com/beetbasket/runner/pkg/message"
+	"github.com/beetbasket/runner/pkg/message/output"
+	"io"
+	"slices"
+	"sync"
+)
+
+// StdinWriter writes to the child's stdin, Close ends stdin like CloseInput and keeps the tunnel open.
+// Use it for children that run stdionet, and StdinPipe for any other child.
+func (cmd *Cmd) StdinWriter() io.WriteCloser {
+	return &stdinWriter{cmd: cmd, closed: make(chan struct{})}
+}
+
+// StdinPipe works like exec.Cmd.StdinPipe, Close closes the pipe so the child reads EOF.
+// If packets are passed on stdin this also ends the tunnel, so children that run stdionet should use StdinWriter.
+func (cmd *Cmd) StdinPipe() io.WriteCloser {
+	return &stdinWriter{cmd: cmd, hard: true, closed: make(chan struct{})}
+}
+
+// stdinWriter blocks each Write until it is written to the child, Close fails writes that are still waiting
+type stdinWriter struct {
+	cmd    *Cmd
+	hard   bool
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (sw *stdinWriter) Write(b []byte) (int, error) {
+	select {
+	case <-sw.closed:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	if err := sw.cmd.ctx.Err(); err != nil {
+		return 0, err
+	}
+	done := make(chan error, 1)
+	sw.cmd.queue(writeInput{data: slices.Clone(b), done: done})
+	select {
+	case err := <-done:
+		if err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	case <-sw.closed:
+		return 0, io.ErrClosedPipe
+	case <-sw.cmd.ctx.Done():
+		return 0, sw.cmd.ctx.Err()
+	}
+}
+
+func (sw *stdinWriter) Close() error {
+	sw.once.Do(func() {
+		close(sw.closed)
+		if sw.hard {
+			sw.cmd.closeInput()
+		} else {
+			sw.cmd.CloseInput()
+		}
+	})
+	return nil
+}
+
+// writeInput is stdin data that reports on done once it is written to the child
+type writeInput struct {
+	data []byte
+	done chan<- error
+}
+
+func (wi writeInput) Input() []byte { return wi.data }
+
+// StdoutReader reads the child's stdout until it exits, it should be called before Start unless the output is replayed.
+func (cmd *Cmd) StdoutReader() io.Reader {
+	return cmd.streamReader(func(msg message.Message) ([]byte, bool) {
+		out, ok := msg.(output.StdoutMessage)
+		return out.Data, ok
+	})
+}
+
+func (cmd *Cmd) StderrReader() io.Reader {
+	return cmd.streamReader(func(msg message.Message) ([]byte, bool) {
+		out, ok := msg.(output.StderrMessage)
+		return out.Data, ok
+	})
+}
+
+func (cmd *Cmd) streamReader(data func(message.Message) ([]byte, bool)) io.Reader {
+	sr := &streamReader{}
+	sr.cond.L = &sr.lock
+	// Output completes when the child exits, and replayed output can still be read after that
+	msgs := cmd.Output(context.Background())
+	go func() {
+		defer sr.close()
+		for msg := range msgs {
+			if b, ok := data(msg); ok {
+				sr.write(b)
+			}
+		}
+	}()
+	return sr
+}
+
+// streamReader buffers without limit so a slow reader never blocks other subscribers
+type streamReader struct {
+	lock sync.Mutex
+	cond sync.Cond
+	buf  bytes.Buffer
+	done bool
+}
+
+func (sr *streamReader) Read(b []byte) (int, error) {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	for sr.buf.Len() == 0 && !sr.done {
+		sr.cond.Wait()
+	}
+	if sr.buf.Len() == 0 {
+		return 0, io.EOF
+	}
+	return sr.buf.Read(b)
+}
+
+func (sr *streamReader) write(b []byte) {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	sr.buf.Write(b)
+	sr.cond.Broadcast()
+}
+
+func (sr *streamReader) close() {
+	sr.lock.Lock()
+	defer sr.lock.Unlock()
+	sr.done = true
+	sr.cond.Broadcast()
+}
@@ -0,0 +1,94 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStdinPipe(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd, err := New(ctx, NewCommandArgs("wc", []string{"-c"}))
+	require.NoError(t, err)
+	defer cmd.Close()
+	stdout := cmd.StdoutReader()
+	stdin := cmd.StdinPipe()
+	cmd.Start()
+
+	// More than a pipe buffer, so writes have to wait for wc to read
+	data := bytes.Repeat([]byte("x"), 1<<20)
+	n, err := stdin.Write(data)
+	require.NoError(t, err)
+	assert.Equal(t, len(data), n)
+	require.NoError(t, stdin.Close())
+	_, err = stdin.Write(data)
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+
+	b, err := io.ReadAll(stdout)
+	require.NoError(t, err)
+	assert.Equal(t, "1048576", strings.TrimSpace(string(b)))
+	assert.Zero(t, cmd.Stats().QueueDepth)
+}
+
+func TestStdinPipeCloseDuringWrite(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd, err := New(ctx, NewCommandArgs("sleep", []string{"10"}))
+	require.NoError(t, err)
+	defer cmd.Close()
+	stdin := cmd.StdinPipe()
+	cmd.Start()
+
+	// sleep never reads, so this waits once the pipe buffer is full
+	errs := make(chan error, 1)
+	go func() {
+		_, err := stdin.Write(bytes.Repeat([]byte("x"), 1<<20))
+		errs <- err
+	}()
+	select {
+	case err := <-errs:
+		t.Fatalf("write finished early: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.NoError(t, stdin.Close())
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, io.ErrClosedPipe)
+	case <-time.After(time.Second):
+		t.Fatal("Close did not fail the pending write")
+	}
+}
+
+func TestStdinPipeChildExited(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd, err := New(ctx, NewCommandArgs("true"))
+	require.NoError(t, err)
+	defer cmd.Close()
+	stdin := cmd.StdinPipe()
+	cmd.Start()
+	<-cmd.Wait()
+
+	_, err = stdin.Write([]byte("nobody reads this\n"))
+	assert.Error(t, err)
+}
+
+func TestStdoutReaderAfterExit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd, err := New(ctx, NewCommandArgs("echo", []string{"replayed"}), WithFullReplay())
+	require.NoError(t, err)
+	defer cmd.Close()
+	cmd.Start()
+	<-cmd.Wait()
+
+	b, err := io.ReadAll(cmd.StdoutReader())
+	require.NoError(t, err)
+	assert.Equal(t, "replayed\n", string(b))
+}
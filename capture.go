@@ -0,0 +1,18 @@
+package runner
+
+import (
+	"github.com/beetbasket/runner/pkg/pcap"
+	"io"
+	"time"
+)
+
+// WithCapture writes every packet through the tunnel to w as pcapng, inbound packets are the ones from the child.
+func WithCapture(w io.Writer) Option {
+	return func(o *options) { o.capture = w }
+}
+
+func (cmd *Cmd) capturePacket(dir pcap.Direction, packet []byte) {
+	if cmd.capture != nil {
+		_ = cmd.capture.WritePacket(time.Now(), dir, packet)
+	}
+}
@@ -87,12 +87,13 @@ type Message struct {
 }
 
 func childMain() {
-	slog.SetDefault(slog.New(slog.NewTextHandler(stdionet.Environment().Stdout(), nil)).With(
+	nt := must(stdionet.Open(context.Background()))
+	defer do(nt.Close)
+	slog.SetDefault(slog.New(slog.NewTextHandler(nt.Stdout(), nil)).With(
 		slog.Bool("child", true),
 	))
 
 	slog.Info("child started")
-	nt := stdionet.Environment()
 
 	resp := must((&http.Client{
 		Transport: &http.Transport{
@@ -112,7 +113,7 @@ func childMain() {
 		slog.Info("child making request", slog.String("data", buf.String()))
 		return &buf
 	}()))
-	must(io.Copy(os.Stdout, resp.Body))
+	must(io.Copy(nt.Stdout(), resp.Body))
 	resp.Body.Close()
 	slog.Info("request made, exiting")
 	nt.Exit(5)
@@ -2,11 +2,14 @@ package runner
 
 import (
 	"context"
+	"encoding/base64"
 	"fmt"
 	"github.com/beetbasket/runner/pkg/ipv4"
 	"github.com/beetbasket/runner/pkg/message"
 	"github.com/beetbasket/runner/pkg/message/input"
 	"github.com/beetbasket/runner/pkg/message/output"
+	"github.com/beetbasket/runner/pkg/pcap"
+	"github.com/beetbasket/runner/pkg/stats"
 	"github.com/beetbasket/rx"
 	"github.com/google/uuid"
 	"github.com/point-c/wg"
@@ -14,29 +17,55 @@ import (
 	"io"
 	"math/rand/v2"
 	"net"
+	"os"
 	"os/exec"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type Cmd struct {
-	in  rx.Subject[message.Input]
-	out rx.Subject[message.Message]
+	in     rx.Subject[message.Input]
+	out    rx.Subject[sequenced]
+	replay replay
 
 	cmd    *exec.Cmd
+	opts   options
 	ctx    context.Context
 	cancel context.CancelFunc
 
 	netstack *wg.Netstack
 	prefix   string
 	address  net.IP
+	key      []byte
+	session  *ipv4.Session
+	counters stats.Counters
+	queued   atomic.Int64
+	capture  *pcap.Writer
+	// queueLock orders queueing against pipeInput giving up, so QueueDepth drops to 0 and stays there
+	queueLock   sync.Mutex
+	queueClosed bool
+
+	pty             *os.File
+	closeAfterStart []io.Closer
+	closeAfterWait  []io.Closer
+	readers         []func()
+
+	span cmdSpan
+
+	lastActivity atomic.Int64
+	active       atomic.Bool
+	reason       atomic.Pointer[output.ExitReason]
 
 	started atomic.Bool
 	wait    chan struct{}
 	waitErr error
+	status  ExitStatus
+	ran     bool
 }
 
-func New(ctx context.Context, cmd CommandArgsEnv) (_ *Cmd, finalErr error) {
+func New(ctx context.Context, cmd CommandArgsEnv, opts ...Option) (_ *Cmd, finalErr error) {
 	finally, cleanup := CheckOk()
 	// Setup networking
 	netstack, err := wg.NewDefaultNetstack()
@@ -51,14 +80,24 @@ func New(ctx context.Context, cmd CommandArgsEnv) (_ *Cmd, finalErr error) {
 	c := Cmd{
 		ctx:      ctx,
 		cancel:   cancel,
+		opts:     newOptions(opts),
 		netstack: netstack,
 		prefix:   generatePrefix(),
 		address:  ipv4.GenerateRandomIPv4(),
+		key:      ipv4.GenerateKey(),
 		wait:     make(chan struct{}),
 	}
+	c.session = ipv4.NewSession(c.key, ipv4.Parent)
+	if c.opts.capture != nil {
+		if c.capture, err = pcap.NewWriter(c.opts.capture); err != nil {
+			return nil, err
+		}
+	}
+	c.span.command = cmd.Command()
 
 	// Make command and setup io
-	in, err := c.initializeCommand(cmd)
+	defer cleanup(func() { c.closeFiles(true) })
+	in, packets, err := c.initializeCommand(cmd)
 	if err != nil {
 		return nil, err
 	}
@@ -67,7 +106,7 @@ func New(ctx context.Context, cmd CommandArgsEnv) (_ *Cmd, finalErr error) {
 	// Make sure close is run at lease once if one of the goroutines cancels the context
 	stop := context.AfterFunc(ctx, func() { c.Close() })
 	defer cleanup(func() { stop() })
-	go c.pipeInput(in)
+	go c.pipeInput(in, packets, c.in.Subscribe(c.ctx))
 	go c.pipePackets()
 
 	finally()
@@ -89,22 +128,24 @@ func (cmd *Cmd) Input(in message.Input) {
 	if _, ok := in.(input.PacketInput); ok || in == nil {
 		return
 	}
-	cmd.in.Next(in)
+	cmd.queue(in)
 }
 
 func (cmd *Cmd) Dial(ctx context.Context, addr *net.TCPAddr) (net.Conn, error) {
-	return cmd.netstack.Net().Dialer(cmd.address, 0).DialTCP(ctx, addr)
+	return cmd.counters.Conn(cmd.traceDial(ctx, addr, func(ctx context.Context) (net.Conn, error) {
+		return cmd.netstack.Net().Dialer(cmd.address, 0).DialTCP(ctx, addr)
+	}))
 }
 
 func (cmd *Cmd) Listen(port uint16) (net.Listener, error) {
-	return cmd.netstack.Net().Listen(&net.TCPAddr{
+	return cmd.counters.Listener(cmd.netstack.Net().Listen(&net.TCPAddr{
 		IP:   cmd.address,
 		Port: int(port),
-	})
+	}))
 }
 
 func (cmd *Cmd) Output(ctx context.Context) <-chan message.Message {
-	return cmd.out.Subscribe(ctx)
+	return cmd.Subscribe(ctx)
 }
 
 func (cmd *Cmd) Start() {
@@ -114,30 +155,72 @@ func (cmd *Cmd) Start() {
 }
 
 func (cmd *Cmd) runCmd() {
+	cmd.status = ExitStatus{Code: -1}
+	cmd.startSpan()
 	defer cmd.cleanupCmd(true)
-	cmd.out.Next(output.NewStartMessage())
-
-	if err := cmd.cmd.Run(); err != nil {
-		if exit := new(exec.ExitError); errors.As(err, &exit) {
-			cmd.exitComplete(exit.ExitCode())
-		} else {
-			cmd.exitComplete(-1)
-			cmd.waitErr = errors.Join(cmd.waitErr, err)
-		}
+	cmd.emit(output.NewStartMessage())
+
+	start := time.Now()
+	err := cmd.run()
+	cmd.status = newExitStatus(err, time.Since(start))
+	if exit := new(exec.ExitError); err != nil && !errors.As(err, &exit) {
+		cmd.waitErr = errors.Join(cmd.waitErr, err)
 	}
 }
 
-func (cmd *Cmd) exitComplete(code int) {
-	cmd.out.Complete(output.NewExitMessage(code))
+func (cmd *Cmd) run() error {
+	cmd.cmd.Env = append(cmd.cmd.Env, cmd.traceEnv()...)
+	err := cmd.cmd.Start()
+	cmd.closeFiles(false)
+	if err != nil {
+		return err
+	}
+	cmd.spanEvent("exec")
+	start := time.Now()
+	cmd.lastActivity.Store(start.UnixNano())
+	go cmd.watchdog(start)
+	go cmd.reportStats()
+
+	var wg sync.WaitGroup
+	for _, fn := range cmd.readers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
+	}
+	err = cmd.cmd.Wait()
+	wg.Wait()
+	return err
+}
+
+func (cmd *Cmd) closeFiles(wait bool) {
+	for _, c := range cmd.closeAfterStart {
+		_ = c.Close()
+	}
+	cmd.closeAfterStart = nil
+	if wait {
+		for _, c := range cmd.closeAfterWait {
+			_ = c.Close()
+		}
+		cmd.closeAfterWait = nil
+	}
 }
 
 func (cmd *Cmd) cleanupCmd(started bool) {
+	cmd.closeFiles(true)
 	cmd.waitErr = errors.Join(cmd.waitErr, cmd.netstack.Close())
+	if !started {
+		cmd.status = ExitStatus{Code: -1}
+	}
+	cmd.status.Reason = cmd.exitReason()
+	cmd.ran = started
+	cmd.endSpan(cmd.status.Code, cmd.waitErr)
 	close(cmd.wait)
 	if started {
-		cmd.exitComplete(0)
+		cmd.complete(output.NewExitMessage(cmd.status.Code, cmd.status.Reason))
 	} else {
-		cmd.out.Complete()
+		cmd.complete()
 	}
 }
 
@@ -168,12 +251,59 @@ func generatePrefix() string {
 	return sb.String()
 }
 
-func (cmd *Cmd) initializeCommand(cae CommandArgsEnv) (io.WriteCloser, error) {
+func (cmd *Cmd) initializeCommand(cae CommandArgsEnv) (stdin, packets io.WriteCloser, err error) {
+	packetInput, packetOutput, err := cmd.opts.packetStreams()
+	if err != nil {
+		return nil, nil, err
+	}
+
 	cmd.cmd = exec.CommandContext(cmd.ctx, cae.Command(), cae.Args()...)
+	if cmd.opts.sandbox != nil {
+		if err := cmd.opts.sandbox.apply(cmd.cmd); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	inputFD, outputFD := 0, 1
+	if packetInput == StreamFD {
+		if inputFD, packets, err = cmd.packetInputPipe(); err != nil {
+			return nil, nil, err
+		}
+	}
+	switch packetOutput {
+	case StreamStderr:
+		outputFD = 2
+	case StreamFD:
+		if outputFD, err = cmd.packetOutputPipe(); err != nil {
+			return nil, nil, err
+		}
+	}
 	cmd.cmd.Env = append(cae.Environment(),
 		fmt.Sprintf("PACKET_PREFIX=%s", cmd.prefix),
 		fmt.Sprintf("PARENT_ADDRESS=%s", cmd.address.String()),
+		fmt.Sprintf("PACKET_INPUT_FD=%d", inputFD),
+		fmt.Sprintf("PACKET_OUTPUT_FD=%d", outputFD),
+		fmt.Sprintf("PACKET_KEY=%s", base64.StdEncoding.EncodeToString(cmd.key)),
 	)
-	cmd.cmd.Stdout, cmd.cmd.Stderr = cmd.newKindWriters()
-	return cmd.cmd.StdinPipe()
+
+	if cmd.opts.pty != nil {
+		stdin, err = cmd.initializePTY()
+	} else {
+		stdoutPrefix, stderrPrefix := "", ""
+		switch packetOutput {
+		case StreamStdout:
+			stdoutPrefix = cmd.prefix
+		case StreamStderr:
+			stderrPrefix = cmd.prefix
+		}
+		cmd.cmd.Stdout = newKindWriter[output.StdoutMessage](cmd, stdoutPrefix)
+		cmd.cmd.Stderr = newKindWriter[output.StderrMessage](cmd, stderrPrefix)
+		stdin, err = cmd.cmd.StdinPipe()
+	}
+	if err != nil {
+		return nil, nil, err
+	} else if packets == nil {
+		packets = stdin
+	}
+	return stdin, packets, nil
 }
@@ -0,0 +1,157 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"github.com/beetbasket/runner/pkg/message"
+	"github.com/beetbasket/runner/pkg/message/output"
+	"github.com/beetbasket/rx"
+	"github.com/trymoose/errors"
+	"sync"
+)
+
+var ErrGroupDone = errors.New("group is done")
+
+type GroupOption func(*groupOptions)
+
+type groupOptions struct {
+	limit    int
+	failFast bool
+	cmd      []Option
+}
+
+// WithConcurrency runs at most n children at once, the rest wait for a slot. 0 is unlimited.
+func WithConcurrency(n int) GroupOption {
+	return func(o *groupOptions) { o.limit = n }
+}
+
+// WithFailFast cancels every child once one fails, by default all children run to completion.
+func WithFailFast() GroupOption {
+	return func(o *groupOptions) { o.failFast = true }
+}
+
+// WithCommandOptions are passed to New for every child.
+func WithCommandOptions(opts ...Option) GroupOption {
+	return func(o *groupOptions) { o.cmd = append(o.cmd, opts...) }
+}
+
+// Group runs many commands and merges their output, every message is an output.ChildMessage.
+type Group struct {
+	opts   groupOptions
+	ctx    context.Context
+	cancel context.CancelFunc
+	slots  chan struct{}
+	out    rx.Subject[message.Message]
+	wg     sync.WaitGroup
+
+	lock sync.Mutex
+	done bool
+	errs []error
+	// cause is the failure that cancelled the group under fail-fast
+	cause    error
+	closeErr error
+	complete sync.Once
+}
+
+func NewGroup(ctx context.Context, opts ...GroupOption) *Group {
+	var o groupOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	g := Group{opts: o, ctx: ctx, cancel: cancel}
+	if o.limit > 0 {
+		g.slots = make(chan struct{}, o.limit)
+	}
+	return &g
+}
+
+// Go starts cmd as soon as there is a free slot, id tags its output and errors.
+func (g *Group) Go(id string, cmd CommandArgsEnv) error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.done {
+		return ErrGroupDone
+	}
+	g.wg.Add(1)
+	go g.run(id, cmd)
+	return nil
+}
+
+func (g *Group) run(id string, ca CommandArgsEnv) {
+	defer g.wg.Done()
+	if g.slots != nil {
+		select {
+		case <-g.ctx.Done():
+			g.fail(id, ErrNotStarted)
+			return
+		case g.slots <- struct{}{}:
+			defer func() { <-g.slots }()
+		}
+	}
+
+	cmd, err := New(g.ctx, ca, g.opts.cmd...)
+	if err != nil {
+		g.fail(id, err)
+		return
+	}
+	defer func() {
+		if err := cmd.Close(); err != nil {
+			g.lock.Lock()
+			defer g.lock.Unlock()
+			g.closeErr = errors.Join(g.closeErr, fmt.Errorf("%s: %w", id, err))
+		}
+	}()
+
+	// Cancelling the group kills the child, its output still has to be forwarded up to the exit message
+	msgs := cmd.Output(context.Background())
+	cmd.Start()
+	for msg := range msgs {
+		g.out.Next(output.NewChildMessage(id, msg))
+	}
+	if _, err := cmd.Result(context.Background()); err != nil {
+		g.fail(id, err)
+	}
+}
+
+func (g *Group) fail(id string, err error) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	err = fmt.Errorf("%s: %w", id, err)
+	g.errs = append(g.errs, err)
+	if g.opts.failFast && g.ctx.Err() == nil {
+		g.cause = err
+		g.cancel()
+	}
+}
+
+func (g *Group) Output(ctx context.Context) <-chan message.Message {
+	return g.out.Subscribe(ctx)
+}
+
+// Wait blocks until every child has exited, after that Go fails and Output completes.
+// With fail-fast it returns the failure that cancelled the group, otherwise every failure joined.
+func (g *Group) Wait() error {
+	g.lock.Lock()
+	g.done = true
+	g.lock.Unlock()
+
+	g.wg.Wait()
+	g.complete.Do(func() { g.out.Complete() })
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.cause != nil {
+		return g.cause
+	}
+	return errors.Join(g.errs...)
+}
+
+// Close kills every child and waits for them, it returns errors from closing the children.
+func (g *Group) Close() error {
+	g.cancel()
+	_ = g.Wait()
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return g.closeErr
+}
@@ -0,0 +1,150 @@
+package runner
+
+import (
+	"context"
+	"github.com/beetbasket/runner/pkg/message/output"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGroupConcurrency(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	g := NewGroup(ctx, WithConcurrency(2))
+	defer g.Close()
+	msgs := g.Output(ctx)
+	for _, id := range []string{"a", "b", "c", "d", "e"} {
+		require.NoError(t, g.Go(id, NewCommandArgs("sleep", []string{"0.05"})))
+	}
+
+	var running, most int
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range msgs {
+			switch msg.(output.ChildMessage).Msg.(type) {
+			case output.StartMessage:
+				running++
+				most = max(most, running)
+			case output.ExitMessage:
+				running--
+			}
+		}
+	}()
+	require.NoError(t, g.Wait())
+	<-done
+	assert.Equal(t, 2, most)
+	assert.Zero(t, running)
+}
+
+func TestGroupOutput(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	g := NewGroup(ctx)
+	defer g.Close()
+	msgs := g.Output(ctx)
+	require.NoError(t, g.Go("one", NewCommandArgs("echo", []string{"1"})))
+	require.NoError(t, g.Go("two", NewCommandArgs("echo", []string{"2"})))
+
+	var lock sync.Mutex
+	stdout := map[string]string{}
+	exits := map[string]int{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for msg := range msgs {
+			child, ok := msg.(output.ChildMessage)
+			if !assert.True(t, ok, "every message is tagged, got %T", msg) {
+				continue
+			}
+			lock.Lock()
+			switch msg := child.Msg.(type) {
+			case output.StdoutMessage:
+				stdout[child.ID] += string(msg.Data)
+			case output.ExitMessage:
+				exits[child.ID]++
+			}
+			lock.Unlock()
+		}
+	}()
+	require.NoError(t, g.Wait())
+	<-done
+	assert.Equal(t, map[string]string{"one": "1\n", "two": "2\n"}, stdout)
+	assert.Equal(t, map[string]int{"one": 1, "two": 1}, exits)
+
+	assert.ErrorIs(t, g.Go("three", NewCommandArgs("true")), ErrGroupDone)
+}
+
+func TestGroupFailure(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		opts     []GroupOption
+		slow     string
+		slowCode int
+	}{
+		{
+			name: "wait for all",
+			slow: "0.2",
+		},
+		{
+			name:     "fail fast",
+			opts:     []GroupOption{WithFailFast()},
+			slow:     "10",
+			slowCode: -1,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			g := NewGroup(ctx, tt.opts...)
+			defer g.Close()
+			msgs := g.Output(ctx)
+			require.NoError(t, g.Go("slow", NewCommandArgs("sleep", []string{tt.slow})))
+			require.NoError(t, g.Go("fail", NewCommandArgs("sh", []string{"-c", "sleep 0.05; exit 3"})))
+
+			exits := map[string]int{}
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for msg := range msgs {
+					child := msg.(output.ChildMessage)
+					if exit, ok := child.Msg.(output.ExitMessage); ok {
+						exits[child.ID] = exit.Code
+					}
+				}
+			}()
+			err := g.Wait()
+			<-done
+			require.NoError(t, ctx.Err())
+
+			require.Error(t, err)
+			var exit *ExitError
+			require.ErrorAs(t, err, &exit)
+			assert.Equal(t, 3, exit.Code)
+			assert.Contains(t, err.Error(), "fail: ")
+			assert.NotContains(t, err.Error(), "slow: ", "a sibling killed by fail-fast is not reported")
+
+			// A killed sibling still reports its exit
+			assert.Equal(t, map[string]int{"fail": 3, "slow": tt.slowCode}, exits)
+		})
+	}
+}
+
+func TestGroupClose(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	g := NewGroup(ctx, WithConcurrency(1))
+	require.NoError(t, g.Go("running", NewCommandArgs("sleep", []string{"10"})))
+	require.NoError(t, g.Go("queued", NewCommandArgs("sleep", []string{"10"})))
+	time.Sleep(50 * time.Millisecond)
+
+	require.NoError(t, g.Close())
+	require.NoError(t, ctx.Err(), "Close kills the children instead of waiting for them")
+	err := g.Wait()
+	assert.ErrorIs(t, err, ErrNotStarted, "the queued child never started")
+	assert.Contains(t, err.Error(), "running: ")
+	assert.ErrorIs(t, g.Go("late", NewCommandArgs("true")), ErrGroupDone)
+}
@@ -0,0 +1,10 @@
+package runner
+
+import (
+	"github.com/beetbasket/runner/pkg/impair"
+)
+
+// WithImpairment degrades the link carrying packets to the child, for testing how it copes with a bad network.
+func WithImpairment(cfg impair.Config) Option {
+	return func(o *options) { o.impairment = &cfg }
+}
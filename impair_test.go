@@ -0,0 +1,83 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"github.com/beetbasket/runner/pkg/impair"
+	"github.com/beetbasket/runner/pkg/stdionet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+const envImpairChild = "RUNNER_TEST_IMPAIR_CHILD"
+
+// TestImpairChild is the child of TestImpairment, it prints its address and echoes connections on port 80
+func TestImpairChild(t *testing.T) {
+	if os.Getenv(envImpairChild) == "" {
+		t.Skip("only runs as a child of TestImpairment")
+	}
+	sn, err := stdionet.Open(context.Background())
+	if err != nil {
+		os.Exit(2)
+	}
+	ln, err := sn.Listen(80)
+	if err != nil {
+		os.Exit(3)
+	}
+	_, _ = sn.Stdout().Write([]byte(sn.Address().String() + "\n"))
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			os.Exit(0)
+		}
+		go func() {
+			defer conn.Close()
+			_, _ = io.Copy(conn, conn)
+		}()
+	}
+}
+
+func TestImpairment(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		cfg  impair.Config
+	}{
+		{name: "clean"},
+		{name: "lossy", cfg: impair.Config{Seed: 1, Loss: 0.05, Delay: time.Millisecond, Jitter: time.Millisecond}},
+		{name: "duplicates", cfg: impair.Config{Seed: 2, Duplicate: 0.1}},
+		{name: "reordered", cfg: impair.Config{Seed: 3, Reorder: 0.1, ReorderDelay: 3 * time.Millisecond}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			cmd, err := New(ctx, NewCommandArgs(os.Args[0], []string{"-test.run=^TestImpairChild$"}, []string{envImpairChild + "=1"}), WithImpairment(tt.cfg))
+			require.NoError(t, err)
+			defer cmd.Close()
+			stdout := bufio.NewReader(cmd.StdoutReader())
+			cmd.Start()
+
+			line, err := stdout.ReadString('\n')
+			require.NoError(t, err)
+			ip := net.ParseIP(line[:len(line)-1]).To4()
+			require.NotNil(t, ip, "child address %q", line)
+
+			conn, err := cmd.Dial(ctx, &net.TCPAddr{IP: ip, Port: 80})
+			require.NoError(t, err)
+			defer conn.Close()
+			want := make([]byte, 128<<10)
+			_, _ = rand.Read(want)
+			go func() { _, _ = conn.Write(want) }()
+			got := make([]byte, len(want))
+			_, err = io.ReadFull(conn, got)
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(want, got))
+		})
+	}
+}
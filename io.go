@@ -1,83 +1,159 @@
 package runner
 
 import (
+	"bytes"
 	"context"
-	"github.com/beetbasket/runner/pkg/ipv4"
+	"github.com/beetbasket/runner/pkg/impair"
 	"github.com/beetbasket/runner/pkg/matcher"
 	"github.com/beetbasket/runner/pkg/message"
 	"github.com/beetbasket/runner/pkg/message/input"
 	"github.com/beetbasket/runner/pkg/message/output"
-	"github.com/beetbasket/rx"
+	"github.com/beetbasket/runner/pkg/pcap"
 	"github.com/point-c/wg"
 	"io"
 	"slices"
 )
 
-func (cmd *Cmd) newKindWriters() (*kindWriter[output.StdoutMessage], *kindWriter[output.StderrMessage]) {
-	return &kindWriter[output.StdoutMessage]{
-			out:      &cmd.out,
-			ctx:      cmd.ctx,
-			matcher:  matcher.New(cmd.prefix),
-			netstack: cmd.netstack,
-		}, &kindWriter[output.StderrMessage]{
-			out:      &cmd.out,
-			ctx:      cmd.ctx,
-			matcher:  matcher.New(""),
-			netstack: cmd.netstack,
-		}
+func newKindWriter[K output.StdioLike](cmd *Cmd, prefix string) *kindWriter[K] {
+	kw := &kindWriter[K]{
+		emit:  cmd.emit,
+		ctx:   cmd.ctx,
+		touch: cmd.touch,
+	}
+	kw.matcher = matcher.New(prefix, &kw.buf, cmd.session.Decoder(pcap.Tap(cmd.netstack, cmd.capture, pcap.Inbound)))
+	return kw
 }
 
 type kindWriter[K output.StdioLike] struct {
-	out      *rx.Subject[message.Message]
-	netstack *wg.Netstack
-	ctx      context.Context
-	matcher  *matcher.Matcher
+	emit    func(message.Message)
+	ctx     context.Context
+	matcher *matcher.Matcher
+	buf     bytes.Buffer
+	touch   func()
 }
 
 func (kw *kindWriter[K]) Write(b []byte) (n int, _ error) {
 	if kw.ctx.Err() != nil {
 		return 0, kw.ctx.Err()
 	}
+	kw.touch()
 
-	n, _ = kw.matcher.Write(b)
-	if b := kw.matcher.ReadOut(); len(b) > 0 {
-		kw.out.Next(output.NewStdioMessage[K](b))
-	}
-
-	if b := kw.matcher.ReadSpecial(); len(b) > 0 {
-		ipv4.DecodePackets(kw.netstack, b)
+	_, _ = kw.matcher.Write(b)
+	if kw.buf.Len() > 0 && kw.emit != nil {
+		kw.emit(output.NewStdioMessage[K](slices.Clone(kw.buf.Bytes())))
 	}
+	kw.buf.Reset()
 	return len(b), nil
 }
 
-func (cmd *Cmd) pipeInput(in io.WriteCloser) {
+// pipeInput writes queued input to the child, stdin must be subscribed before anything is queued
+func (cmd *Cmd) pipeInput(in, packets io.WriteCloser, stdin <-chan message.Input) {
+	// Nothing left in the queue will be written
+	defer func() {
+		cmd.queueLock.Lock()
+		defer cmd.queueLock.Unlock()
+		cmd.queueClosed = true
+		cmd.queued.Store(0)
+	}()
 	defer in.Close()
+	defer packets.Close()
 	defer cmd.cancel()
 
-	ctx, cancel := context.WithCancel(cmd.ctx)
-	defer cancel()
-	stdin := cmd.in.Subscribe(ctx)
-	for ctx.Err() == nil {
+	for cmd.ctx.Err() == nil {
 		select {
-		case <-ctx.Done():
+		case <-cmd.ctx.Done():
 			return
 		case data, ok := <-stdin:
-			if ok {
-				b := data.Input()
-				if _, err := in.Write(b); err != nil {
+			if !ok {
+				return
+			}
+			cmd.queued.Add(-1)
+			switch data := data.(type) {
+			case input.PacketInput:
+				if packets == nil {
+					continue
+				} else if _, err := packets.Write(data.Input()); err != nil {
 					return
-				} else if _, ok := data.(input.PacketInput); !ok {
-					cmd.out.Next(output.NewStdioMessage[output.StdinMessage](b))
 				}
-			} else {
-				return
+			case eofInput:
+				if in == nil {
+					continue
+				} else if cmd.pty != nil {
+					// A terminal signals end of input with ^D
+					_, _ = in.Write([]byte{4})
+					continue
+				} else if packets == in && !data.hard {
+					// Stdin carries the tunnel, an empty packet tells stdionet that stdin ended
+					if _, err := packets.Write(cmd.session.Seal(cmd.prefix, nil).Input()); err != nil {
+						return
+					}
+				} else {
+					_ = in.Close()
+					if packets == in {
+						packets = nil
+					}
+				}
+				in = nil
+			case input.WindowSizeInput:
+				if cmd.pty != nil {
+					_ = setWindowSize(cmd.pty, data.Rows, data.Cols)
+				}
+			default:
+				b := data.Input()
+				err := io.ErrClosedPipe
+				if in != nil {
+					if _, err = in.Write(b); err != nil {
+						// The child stopped reading stdin, it can still be writing output
+						if packets == in {
+							packets = nil
+						}
+						in = nil
+					} else {
+						cmd.emit(output.NewStdioMessage[output.StdinMessage](b))
+					}
+				}
+				if wi, ok := data.(writeInput); ok {
+					wi.done <- err
+				}
 			}
 		}
 	}
 }
 
+// eofInput ends stdin, hard closes the pipe even if that ends the tunnel, for children that don't use stdionet
+type eofInput struct{ hard bool }
+
+func (eofInput) Input() []byte { return nil }
+
+// CloseInput closes the child's stdin, the packet tunnel keeps working.
+func (cmd *Cmd) CloseInput() {
+	cmd.queue(eofInput{})
+}
+
+func (cmd *Cmd) closeInput() {
+	cmd.queue(eofInput{hard: true})
+}
+
+func (cmd *Cmd) queue(in message.Input) {
+	cmd.queueLock.Lock()
+	if cmd.queueClosed || cmd.ctx.Err() != nil {
+		cmd.queueLock.Unlock()
+		return
+	}
+	cmd.queued.Add(1)
+	cmd.queueLock.Unlock()
+	cmd.in.Next(in)
+}
+
 func (cmd *Cmd) pipePackets() {
 	defer cmd.cancel()
+	send := func(b []byte) { cmd.queue(cmd.session.Seal(cmd.prefix, b)) }
+	if cmd.opts.impairment != nil {
+		link := impair.New(cmd.ctx, *cmd.opts.impairment, send)
+		defer link.Close()
+		send = link.Send
+	}
+
 	buf := [...][]byte{make([]byte, wg.DefaultMTU*2)}
 	var size [len(buf)]int
 	for cmd.ctx.Err() == nil {
@@ -87,7 +163,8 @@ func (cmd *Cmd) pipePackets() {
 		} else if n > 0 {
 			for i, b := range buf[:n] {
 				if size[i] > 0 {
-					cmd.in.Next(input.NewPacketInput(cmd.prefix, slices.Clone(b[:size[i]])))
+					cmd.capturePacket(pcap.Outbound, b[:size[i]])
+					send(slices.Clone(b[:size[i]]))
 				}
 			}
 		}
@@ -0,0 +1,84 @@
+package runner
+
+import (
+	"bufio"
+	"context"
+	"github.com/beetbasket/runner/pkg/message/input"
+	"github.com/beetbasket/runner/pkg/stdionet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+const envCloseInputChild = "RUNNER_TEST_CLOSE_INPUT_CHILD"
+
+// TestCloseInputChild is the child of TestCloseInput, it echoes on port 80 and reports stdin once it ends
+func TestCloseInputChild(t *testing.T) {
+	if os.Getenv(envCloseInputChild) == "" {
+		t.Skip("only runs as a child of TestCloseInput")
+	}
+	sn, err := stdionet.Open(context.Background())
+	if err != nil {
+		os.Exit(2)
+	}
+	ln, err := sn.Listen(80)
+	if err != nil {
+		os.Exit(3)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(conn, conn)
+			}()
+		}
+	}()
+	_, _ = sn.Stdout().Write([]byte(sn.Address().String() + "\n"))
+	b, err := io.ReadAll(sn.Stdin())
+	if err != nil {
+		os.Exit(4)
+	}
+	_, _ = sn.Stdout().Write([]byte("stdin: " + string(b)))
+	select {}
+}
+
+func TestCloseInput(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cmd, err := New(ctx, NewCommandArgs(os.Args[0], []string{"-test.run=^TestCloseInputChild$"}, []string{envCloseInputChild + "=1"}))
+	require.NoError(t, err)
+	defer cmd.Close()
+	stdout := bufio.NewReader(cmd.StdoutReader())
+	cmd.Start()
+
+	line, err := stdout.ReadString('\n')
+	require.NoError(t, err)
+	ip := net.ParseIP(line[:len(line)-1]).To4()
+	require.NotNil(t, ip, "child address %q", line)
+
+	cmd.Input(input.NewInputln("hello"))
+	cmd.CloseInput()
+	line, err = stdout.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "stdin: hello\n", line)
+
+	// Only stdin ended, the tunnel still works
+	conn, err := cmd.Dial(ctx, &net.TCPAddr{IP: ip, Port: 80})
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	b := make([]byte, 4)
+	_, err = io.ReadFull(conn, b)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(b))
+	assert.Equal(t, uint64(0), cmd.Stats().Packets.DecodeFailures())
+}
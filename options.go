@@ -0,0 +1,59 @@
+package runner
+
+import (
+	"github.com/beetbasket/runner/pkg/impair"
+	"github.com/beetbasket/runner/pkg/trace"
+	"io"
+	"time"
+)
+
+type Option func(*options)
+
+type options struct {
+	sandbox  *sandbox
+	pty      *ptyOptions
+	timeouts timeouts
+
+	packetInput, packetOutput Stream
+	statsInterval             time.Duration
+	tracer                    *trace.Tracer
+	capture                   io.Writer
+	impairment                *impair.Config
+	replay                    replayOptions
+}
+
+func newOptions(opts []Option) (o options) {
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+type Bind struct {
+	Source, Target string
+}
+
+type sandbox struct {
+	binds []Bind
+}
+
+func (o *options) withSandbox() *sandbox {
+	if o.sandbox == nil {
+		o.sandbox = new(sandbox)
+	}
+	return o.sandbox
+}
+
+// WithSandbox starts the child in new user, network, mount and pid namespaces.
+// Its only network path is the packet tunnel. It sees a fresh /proc and /sys rather than the host's.
+func WithSandbox(binds ...Bind) Option {
+	return func(o *options) {
+		sb := o.withSandbox()
+		sb.binds = append(sb.binds, binds...)
+	}
+}
+
+// WithReadOnlyBind bind mounts source over target read-only inside the sandbox. It implies WithSandbox.
+func WithReadOnlyBind(source, target string) Option {
+	return WithSandbox(Bind{Source: source, Target: target})
+}
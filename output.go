@@ -0,0 +1,107 @@
+package runner
+
+import (
+	"context"
+	"github.com/beetbasket/runner/pkg/message"
+	"github.com/beetbasket/runner/pkg/message/output"
+	"slices"
+)
+
+type OutputOption func(*outputOptions)
+
+type outputOptions struct {
+	live    bool
+	filters []func(message.Message) bool
+}
+
+// WithoutReplay only delivers messages sent after subscribing.
+func WithoutReplay() OutputOption {
+	return func(o *outputOptions) { o.live = true }
+}
+
+// WithKinds only delivers messages of the given kinds, like output.KindStdout.
+func WithKinds(kinds ...string) OutputOption {
+	return WithFilter(func(msg message.Message) bool {
+		return slices.Contains(kinds, output.KindOf(msg))
+	})
+}
+
+// WithFilter only delivers messages fn returns true for, multiple filters must all match.
+func WithFilter(fn func(message.Message) bool) OutputOption {
+	return func(o *outputOptions) { o.filters = append(o.filters, fn) }
+}
+
+func (o *outputOptions) match(msg message.Message) bool {
+	for _, fn := range o.filters {
+		if !fn(msg) {
+			return false
+		}
+	}
+	return true
+}
+
+// Subscribe is Output with options, by default it starts with the replayed messages.
+// Each subscription has a goroutine that applies its filters, so messages it filters out are still queued for it.
+func (cmd *Cmd) Subscribe(ctx context.Context, opts ...OutputOption) <-chan message.Message {
+	return subscribe[message.Message](ctx, cmd, opts)
+}
+
+// Stdout delivers the child's stdout messages.
+func (cmd *Cmd) Stdout(ctx context.Context, opts ...OutputOption) <-chan output.StdoutMessage {
+	return subscribe[output.StdoutMessage](ctx, cmd, opts)
+}
+
+func (cmd *Cmd) Stderr(ctx context.Context, opts ...OutputOption) <-chan output.StderrMessage {
+	return subscribe[output.StderrMessage](ctx, cmd, opts)
+}
+
+// Exits delivers the exit message, if the child was started.
+func (cmd *Cmd) Exits(ctx context.Context, opts ...OutputOption) <-chan output.ExitMessage {
+	return subscribe[output.ExitMessage](ctx, cmd, opts)
+}
+
+// subscribe only delivers messages of type T, the typed subscriptions use it to avoid a second goroutine
+func subscribe[T message.Message](ctx context.Context, cmd *Cmd, opts []OutputOption) <-chan T {
+	var o outputOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	cmd.replay.lock.Lock()
+	var history []message.Message
+	if !o.live {
+		history = slices.Clone(cmd.replay.msgs)
+	}
+	last := cmd.replay.seq
+	live := cmd.out.Subscribe(ctx)
+	cmd.replay.lock.Unlock()
+
+	msgs := make(chan T)
+	go func() {
+		defer close(msgs)
+		send := func(msg message.Message) bool {
+			t, ok := msg.(T)
+			if !ok || !o.match(msg) {
+				return true
+			}
+			select {
+			case <-ctx.Done():
+				return false
+			case msgs <- t:
+				return true
+			}
+		}
+		for _, msg := range history {
+			if !send(msg) {
+				return
+			}
+		}
+		for msg := range live {
+			// Anything numbered up to last was sent before subscribing, and is in the history if it was kept
+			if msg.seq > last && !send(msg.msg) {
+				return
+			}
+		}
+	}()
+	return msgs
+}
@@ -0,0 +1,119 @@
+package runner
+
+import (
+	"context"
+	"github.com/beetbasket/runner/pkg/message"
+	"github.com/beetbasket/runner/pkg/message/output"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSubscribe(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd, err := New(ctx, NewCommandArgs("sh", []string{"-c", "echo out; sleep 0.05; echo err >&2; exit 3"}), WithFullReplay())
+	require.NoError(t, err)
+	defer cmd.Close()
+	cmd.Start()
+	<-cmd.Wait()
+
+	describe := func(msg message.Message) string {
+		switch msg := msg.(type) {
+		case output.StdoutMessage:
+			return "stdout:" + strings.TrimSpace(string(msg.Data))
+		case output.StderrMessage:
+			return "stderr:" + strings.TrimSpace(string(msg.Data))
+		case output.ExitMessage:
+			return "exit:" + strconv.Itoa(msg.Code)
+		}
+		return output.KindOf(msg)
+	}
+	collect := func(msgs <-chan message.Message) (got []string) {
+		for msg := range msgs {
+			got = append(got, describe(msg))
+		}
+		return got
+	}
+	for _, tt := range []struct {
+		name   string
+		msgs   func(context.Context) []string
+		output []string
+	}{
+		{
+			name:   "everything",
+			msgs:   func(ctx context.Context) []string { return collect(cmd.Subscribe(ctx)) },
+			output: []string{"start", "stdout:out", "stderr:err", "exit:3"},
+		},
+		{
+			name: "kinds",
+			msgs: func(ctx context.Context) []string {
+				return collect(cmd.Subscribe(ctx, WithKinds(output.KindStderr, output.KindExit)))
+			},
+			output: []string{"stderr:err", "exit:3"},
+		},
+		{
+			name: "filters must all match",
+			msgs: func(ctx context.Context) []string {
+				return collect(cmd.Subscribe(ctx,
+					WithFilter(func(msg message.Message) bool { return output.KindOf(msg) != output.KindStart }),
+					WithFilter(func(msg message.Message) bool { return output.KindOf(msg) != output.KindExit }),
+				))
+			},
+			output: []string{"stdout:out", "stderr:err"},
+		},
+		{
+			name:   "without replay",
+			msgs:   func(ctx context.Context) []string { return collect(cmd.Subscribe(ctx, WithoutReplay())) },
+			output: nil,
+		},
+		{
+			name: "stdout",
+			msgs: func(ctx context.Context) (got []string) {
+				for msg := range cmd.Stdout(ctx) {
+					got = append(got, describe(msg))
+				}
+				return got
+			},
+			output: []string{"stdout:out"},
+		},
+		{
+			name: "stderr",
+			msgs: func(ctx context.Context) (got []string) {
+				for msg := range cmd.Stderr(ctx) {
+					got = append(got, describe(msg))
+				}
+				return got
+			},
+			output: []string{"stderr:err"},
+		},
+		{
+			name: "exits",
+			msgs: func(ctx context.Context) (got []string) {
+				for msg := range cmd.Exits(ctx) {
+					got = append(got, describe(msg))
+				}
+				return got
+			},
+			output: []string{"exit:3"},
+		},
+		{
+			name: "typed with a filter",
+			msgs: func(ctx context.Context) (got []string) {
+				opts := make([]OutputOption, 0, 4)
+				for msg := range cmd.Stdout(ctx, append(opts, WithKinds(output.KindStderr))...) {
+					got = append(got, describe(msg))
+				}
+				return got
+			},
+			output: nil,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.output, tt.msgs(ctx))
+		})
+	}
+}
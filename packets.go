@@ -0,0 +1,90 @@
+package runner
+
+import (
+	"fmt"
+	"github.com/beetbasket/runner/pkg/message/output"
+	"io"
+	"os"
+)
+
+type Stream int
+
+const (
+	StreamStdin Stream = iota
+	StreamStdout
+	StreamStderr
+	// StreamFD is a dedicated pipe passed to the child as an extra file descriptor
+	StreamFD
+)
+
+func (s Stream) String() string {
+	switch s {
+	case StreamStdin:
+		return "stdin"
+	case StreamStdout:
+		return "stdout"
+	case StreamStderr:
+		return "stderr"
+	case StreamFD:
+		return "fd"
+	default:
+		return fmt.Sprintf("Stream(%d)", int(s))
+	}
+}
+
+// WithPacketInput selects how packets reach the child, either StreamStdin or StreamFD.
+func WithPacketInput(s Stream) Option {
+	return func(o *options) { o.packetInput = s }
+}
+
+// WithPacketOutput selects how the child sends packets, one of StreamStdout, StreamStderr or StreamFD.
+func WithPacketOutput(s Stream) Option {
+	return func(o *options) { o.packetOutput = s }
+}
+
+func (o *options) packetStreams() (in, out Stream, _ error) {
+	in, out = o.packetInput, o.packetOutput
+	if out == StreamStdin {
+		out = StreamStdout
+	}
+	// A terminal would mangle the line framing
+	if o.pty != nil {
+		in, out = StreamFD, StreamFD
+	}
+
+	if in != StreamStdin && in != StreamFD {
+		return 0, 0, fmt.Errorf("invalid packet input stream %s", in)
+	} else if out != StreamStdout && out != StreamStderr && out != StreamFD {
+		return 0, 0, fmt.Errorf("invalid packet output stream %s", out)
+	}
+	return in, out, nil
+}
+
+func (cmd *Cmd) extraFD(f *os.File) int {
+	cmd.cmd.ExtraFiles = append(cmd.cmd.ExtraFiles, f)
+	return 2 + len(cmd.cmd.ExtraFiles)
+}
+
+func (cmd *Cmd) packetInputPipe() (fd int, _ io.WriteCloser, _ error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, nil, err
+	}
+	cmd.closeAfterStart = append(cmd.closeAfterStart, r)
+	cmd.closeAfterWait = append(cmd.closeAfterWait, w)
+	return cmd.extraFD(r), w, nil
+}
+
+func (cmd *Cmd) packetOutputPipe() (fd int, _ error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	cmd.closeAfterStart = append(cmd.closeAfterStart, w)
+	cmd.closeAfterWait = append(cmd.closeAfterWait, r)
+
+	side := newKindWriter[output.StdoutMessage](cmd, cmd.prefix)
+	side.emit = nil
+	cmd.readers = append(cmd.readers, func() { _, _ = io.Copy(side, r) })
+	return cmd.extraFD(w), nil
+}
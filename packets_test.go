@@ -0,0 +1,75 @@
+package runner
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPacketStreams(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		opts    []Option
+		in, out Stream
+		err     string
+	}{
+		{name: "default", in: StreamStdin, out: StreamStdout},
+		{name: "stderr output", opts: []Option{WithPacketOutput(StreamStderr)}, in: StreamStdin, out: StreamStderr},
+		{name: "fds", opts: []Option{WithPacketInput(StreamFD), WithPacketOutput(StreamFD)}, in: StreamFD, out: StreamFD},
+		{name: "stdin output means stdout", opts: []Option{WithPacketOutput(StreamStdin)}, in: StreamStdin, out: StreamStdout},
+		{name: "pty moves both to fds", opts: []Option{WithPacketOutput(StreamStderr), WithPTY(24, 80)}, in: StreamFD, out: StreamFD},
+		{name: "stdout input", opts: []Option{WithPacketInput(StreamStdout)}, err: "invalid packet input stream stdout"},
+		{name: "stderr input", opts: []Option{WithPacketInput(StreamStderr)}, err: "invalid packet input stream stderr"},
+		{name: "unknown input", opts: []Option{WithPacketInput(Stream(9))}, err: "invalid packet input stream Stream(9)"},
+		{name: "unknown output", opts: []Option{WithPacketOutput(Stream(-1))}, err: "invalid packet output stream Stream(-1)"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			o := newOptions(tt.opts)
+			in, out, err := o.packetStreams()
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.in, in)
+			assert.Equal(t, tt.out, out)
+		})
+	}
+}
+
+func TestPacketFDEnv(t *testing.T) {
+	// The child reports the descriptors it was told about and whether they are open
+	const script = `for fd in "$PACKET_INPUT_FD" "$PACKET_OUTPUT_FD"; do
+	[ -e /dev/fd/$fd ] && echo "$fd open" || echo "$fd closed"
+done`
+	for _, tt := range []struct {
+		name   string
+		opts   []Option
+		output string
+	}{
+		{name: "stdio", output: "0 open\n1 open\n"},
+		{name: "stderr output", opts: []Option{WithPacketOutput(StreamStderr)}, output: "0 open\n2 open\n"},
+		{name: "input fd", opts: []Option{WithPacketInput(StreamFD)}, output: "3 open\n1 open\n"},
+		{name: "output fd", opts: []Option{WithPacketOutput(StreamFD)}, output: "0 open\n3 open\n"},
+		{name: "both fds", opts: []Option{WithPacketInput(StreamFD), WithPacketOutput(StreamFD)}, output: "3 open\n4 open\n"},
+		{name: "pty", opts: []Option{WithPTY(24, 80)}, output: "3 open\n4 open\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			cmd, err := New(ctx, NewCommandArgs("sh", []string{"-c", script}), tt.opts...)
+			require.NoError(t, err)
+			defer cmd.Close()
+			stdout := cmd.StdoutReader()
+			cmd.Start()
+
+			b, err := io.ReadAll(stdout)
+			require.NoError(t, err)
+			assert.Equal(t, tt.output, strings.ReplaceAll(string(b), "\r\n", "\n"))
+		})
+	}
+}
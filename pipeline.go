@@ -0,0 +1,259 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"github.com/beetbasket/runner/pkg/message"
+	"github.com/beetbasket/runner/pkg/message/output"
+	"github.com/beetbasket/rx"
+	"github.com/trymoose/errors"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+)
+
+var ErrNotStarted = errors.New("not started")
+
+// Pipeline connects the stdout of each stage to the stdin of the next.
+type Pipeline struct {
+	stages []CommandArgs
+}
+
+type PipelineOutput struct {
+	// Stdout of the last stage, code and error of the last stage that failed
+	Output
+	Stages []Output
+}
+
+func NewPipeline(stages ...CommandArgs) *Pipeline {
+	return &Pipeline{stages: stages}
+}
+
+func environment(ca CommandArgs) []string {
+	if cae, ok := ca.(CommandArgsEnv); ok {
+		return cae.Environment()
+	}
+	return nil
+}
+
+func (p *Pipeline) Run(ctx context.Context, opts ...RunOption) (out PipelineOutput) {
+	var o runOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if len(p.stages) == 0 {
+		return out
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stdout := headTail{limit: o.limit}
+	stderr := make([]headTail, len(p.stages))
+	teeStderr := o.stderr
+	if teeStderr != nil {
+		teeStderr = &syncWriter{w: teeStderr}
+	}
+	cmds := make([]*exec.Cmd, len(p.stages))
+	for i, s := range p.stages {
+		cmds[i] = exec.CommandContext(ctx, s.Command(), s.Args()...)
+		cmds[i].Env = environment(s)
+		cmds[i].Stderr = tee(&stderr[i], teeStderr)
+	}
+	cmds[0].Stdin = o.stdin
+	cmds[len(cmds)-1].Stdout = tee(&stdout, o.stdout)
+
+	out.Stages = make([]Output, len(cmds))
+	for i := range out.Stages {
+		out.Stages[i] = Output{Code: -1, Err: ErrNotStarted}
+	}
+
+	var pipes []io.Closer
+	defer func() {
+		for _, c := range pipes {
+			_ = c.Close()
+		}
+	}()
+	for i := range cmds[1:] {
+		r, w, err := os.Pipe()
+		if err != nil {
+			out.Err, out.Code = err, -1
+			return out
+		}
+		pipes = append(pipes, r, w)
+		cmds[i].Stdout, cmds[i+1].Stdin = w, r
+	}
+
+	started := 0
+	for i, c := range cmds {
+		if err := c.Start(); err != nil {
+			out.Stages[i].Err = err
+			cancel()
+			break
+		}
+		started++
+	}
+	// The children hold their own copies of the pipes
+	for _, c := range pipes {
+		_ = c.Close()
+	}
+	pipes = nil
+
+	for i, c := range cmds[:started] {
+		err := c.Wait()
+		out.Stages[i].Code = c.ProcessState.ExitCode()
+		out.Stages[i].Stderr, out.Stages[i].StderrTruncated = stderr[i].Bytes(), stderr[i].dropped
+		if exit := new(exec.ExitError); errors.As(err, &exit) {
+			out.Stages[i].Err = newExitError(out.Stages[i].Code, out.Stages[i].Stderr, err)
+		} else {
+			out.Stages[i].Err = err
+		}
+	}
+	out.Stdout, out.StdoutTruncated = stdout.Bytes(), stdout.dropped
+	last := &out.Stages[len(out.Stages)-1]
+	last.Stdout, last.StdoutTruncated = out.Stdout, out.StdoutTruncated
+
+	out.Code, out.Err = pipefail(out.Stages)
+	return out
+}
+
+func pipefail(stages []Output) (int, error) {
+	for i := len(stages) - 1; i >= 0; i-- {
+		if errors.Is(stages[i].Err, ErrNotStarted) {
+			continue
+		} else if stages[i].Err != nil || stages[i].Code != 0 {
+			err := stages[i].Error()
+			return stages[i].Code, fmt.Errorf("stage %d: %w", i, err)
+		}
+	}
+	return 0, nil
+}
+
+type syncWriter struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+func (sw *syncWriter) Write(b []byte) (int, error) {
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+	return sw.w.Write(b)
+}
+
+// PipelineCmd is the streaming form of a Pipeline, every stage is a Cmd.
+type PipelineCmd struct {
+	stages []*Cmd
+	out    rx.Subject[message.Message]
+	codes  []int
+	ctx    context.Context
+	cancel context.CancelFunc
+	wait   chan struct{}
+}
+
+func (p *Pipeline) New(ctx context.Context, opts ...Option) (_ *PipelineCmd, finalErr error) {
+	finally, cleanup := CheckOk()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cleanup(cancel)
+
+	pc := PipelineCmd{
+		codes:  make([]int, len(p.stages)),
+		ctx:    ctx,
+		cancel: cancel,
+		wait:   make(chan struct{}),
+	}
+	defer cleanup(func() {
+		for _, s := range pc.stages {
+			finalErr = errors.Join(finalErr, s.Close())
+		}
+	})
+	for _, s := range p.stages {
+		c, err := New(ctx, NewCommandArgs(s.Command(), s.Args(), environment(s)), opts...)
+		if err != nil {
+			return nil, err
+		}
+		pc.stages = append(pc.stages, c)
+	}
+
+	var wg sync.WaitGroup
+	for i, s := range pc.stages {
+		wg.Add(1)
+		go pc.forward(&wg, i, s.Output(ctx))
+	}
+	go func() {
+		wg.Wait()
+		close(pc.wait)
+		code, _ := pc.Result()
+		pc.out.Complete(output.NewExitMessage(code, ""))
+	}()
+
+	finally()
+	return &pc, nil
+}
+
+func (pc *PipelineCmd) forward(wg *sync.WaitGroup, i int, msgs <-chan message.Message) {
+	defer wg.Done()
+	// Each write waits until the next stage has read it, so a slow stage holds up forwarding
+	var next io.Writer
+	if i+1 < len(pc.stages) {
+		next = pc.stages[i+1].StdinPipe()
+		defer pc.stages[i+1].closeInput()
+	}
+
+	pc.codes[i] = -1
+	for msg := range msgs {
+		switch msg := msg.(type) {
+		case output.StdoutMessage:
+			if next != nil {
+				if _, err := next.Write(msg.Data); err != nil {
+					// The next stage is gone, stop this one like SIGPIPE would in a shell
+					pc.stages[i].kill(output.ExitBrokenPipe)
+					next = nil
+				}
+			}
+		case output.ExitMessage:
+			pc.codes[i] = msg.Code
+		}
+		pc.out.Next(output.NewStageMessage(i, msg))
+	}
+}
+
+func (pc *PipelineCmd) Start() {
+	for i := len(pc.stages) - 1; i >= 0; i-- {
+		pc.stages[i].Start()
+	}
+}
+
+func (pc *PipelineCmd) Input(in message.Input) {
+	pc.stages[0].Input(in)
+}
+
+func (pc *PipelineCmd) Output(ctx context.Context) <-chan message.Message {
+	return pc.out.Subscribe(ctx)
+}
+
+func (pc *PipelineCmd) Stage(i int) *Cmd {
+	return pc.stages[i]
+}
+
+func (pc *PipelineCmd) Wait() <-chan struct{} {
+	return pc.wait
+}
+
+// Result reports the exit code of every stage with pipefail semantics, it is only valid after Wait.
+func (pc *PipelineCmd) Result() (int, []int) {
+	for i := len(pc.codes) - 1; i >= 0; i-- {
+		if pc.codes[i] != 0 {
+			return pc.codes[i], pc.codes
+		}
+	}
+	return 0, pc.codes
+}
+
+func (pc *PipelineCmd) Close() (err error) {
+	pc.cancel()
+	for _, s := range pc.stages {
+		err = errors.Join(err, s.Close())
+	}
+	return err
+}
@@ -0,0 +1,133 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"github.com/beetbasket/runner/pkg/message/output"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPipelineRun(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		stages  []CommandArgs
+		timeout time.Duration
+		stdout  string
+		code    int
+		err     string
+		started []bool
+	}{
+		{
+			name:    "success",
+			stages:  []CommandArgs{NewCommandArgs("echo", []string{"abc"}), NewCommandArgs("tr", []string{"a-z", "A-Z"})},
+			stdout:  "ABC\n",
+			started: []bool{true, true},
+		},
+		{
+			name:    "pipefail reports the last failing stage",
+			stages:  []CommandArgs{NewCommandArgs("sh", []string{"-c", "exit 3"}), NewCommandArgs("sh", []string{"-c", "cat; exit 4"}), NewCommandArgs("cat")},
+			code:    4,
+			err:     "stage 1: ",
+			started: []bool{true, true, true},
+		},
+		{
+			name:    "stage fails to start",
+			stages:  []CommandArgs{NewCommandArgs("cat"), NewCommandArgs("/nonexistent/command"), NewCommandArgs("cat")},
+			code:    -1,
+			err:     "stage 1: ",
+			started: []bool{true, true, false},
+		},
+		{
+			name:    "context cancelled",
+			stages:  []CommandArgs{NewCommandArgs("sleep", []string{"10"}), NewCommandArgs("cat")},
+			timeout: 100 * time.Millisecond,
+			code:    -1,
+			err:     "stage 1: ",
+			started: []bool{true, true},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if tt.timeout > 0 {
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			out := NewPipeline(tt.stages...).Run(ctx)
+			assert.Equal(t, tt.stdout, string(out.Stdout))
+			assert.Equal(t, tt.code, out.Code)
+			if tt.err == "" {
+				assert.NoError(t, out.Err)
+			} else {
+				require.Error(t, out.Err)
+				assert.Contains(t, out.Err.Error(), tt.err)
+			}
+			require.Len(t, out.Stages, len(tt.started))
+			for i, started := range tt.started {
+				assert.Equal(t, !started, errors.Is(out.Stages[i].Err, ErrNotStarted), "stage %d", i)
+			}
+		})
+	}
+}
+
+func TestPipelineCmdFinishedUpstream(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	file := filepath.Join(t.TempDir(), "touched")
+	pc, err := NewPipeline(
+		NewCommandArgs("sh", []string{"-c", `sleep 0.2; touch "$1"`, "sh", file}),
+		NewCommandArgs("true"),
+	).New(ctx)
+	require.NoError(t, err)
+	defer pc.Close()
+
+	pc.Start()
+	<-pc.Wait()
+	require.NoError(t, ctx.Err())
+	code, codes := pc.Result()
+	assert.Equal(t, 0, code)
+	assert.Equal(t, []int{0, 0}, codes)
+	assert.FileExists(t, file, "a stage that never writes to the exited stage runs to the end")
+}
+
+func TestPipelineCmdEarlyExit(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pc, err := NewPipeline(
+		NewCommandArgs("yes"),
+		NewCommandArgs("cat"),
+		NewCommandArgs("head", []string{"-n", "1"}),
+	).New(ctx)
+	require.NoError(t, err)
+	defer pc.Close()
+
+	msgs := pc.Output(ctx)
+	pc.Start()
+	var stdout string
+	reasons := map[int]output.ExitReason{}
+	for msg := range msgs {
+		stage, ok := msg.(output.StageMessage)
+		if !ok {
+			continue
+		}
+		switch msg := stage.Msg.(type) {
+		case output.StdoutMessage:
+			if stage.Stage == 2 {
+				stdout += string(msg.Data)
+			}
+		case output.ExitMessage:
+			reasons[stage.Stage] = msg.Reason
+		}
+	}
+
+	require.NoError(t, ctx.Err(), "pipeline should finish once the last stage exits")
+	assert.Equal(t, "y\n", stdout)
+	_, codes := pc.Result()
+	assert.Equal(t, 0, codes[2])
+	assert.Equal(t, output.ExitBrokenPipe, reasons[0])
+	assert.Equal(t, output.ExitBrokenPipe, reasons[1])
+}
@@ -0,0 +1,208 @@
+package impair
+
+import (
+	"container/heap"
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Config describes how a Link degrades the packets sent through it, the zero value delivers everything immediately.
+type Config struct {
+	// Seed for the random decisions, the same seed and traffic gives the same losses, duplicates and reorders
+	Seed      int64
+	Loss      float64
+	Duplicate float64
+	// Reorder is the chance a packet is held back by ReorderDelay so later packets overtake it
+	Reorder      float64
+	ReorderDelay time.Duration
+	Delay        time.Duration
+	// Jitter adds a uniform random delay in [-Jitter, Jitter], packets still arrive in order
+	Jitter time.Duration
+	// Bandwidth in bytes per second, 0 is unlimited
+	Bandwidth int
+	// QueueLimit drops packets once this many are waiting, 0 is unlimited
+	QueueLimit int
+}
+
+type Stats struct {
+	Sent       uint64 `json:"sent"`
+	Delivered  uint64 `json:"delivered"`
+	Dropped    uint64 `json:"dropped"`
+	Duplicated uint64 `json:"duplicated"`
+	Reordered  uint64 `json:"reordered"`
+}
+
+// Link delivers packets to a function after applying the impairments in its Config.
+type Link struct {
+	cfg     Config
+	deliver func([]byte)
+	cancel  func()
+	done    chan struct{}
+	wake    chan struct{}
+
+	lock  sync.Mutex
+	rand  *rand.Rand
+	queue packetQueue
+	seq   uint64
+	busy  time.Time
+	last  time.Time
+	stats Stats
+}
+
+func New(ctx context.Context, cfg Config, deliver func([]byte)) *Link {
+	ctx, cancel := context.WithCancel(ctx)
+	l := &Link{
+		cfg:     cfg,
+		deliver: deliver,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		wake:    make(chan struct{}, 1),
+		rand:    rand.New(rand.NewSource(cfg.Seed)),
+	}
+	go l.run(ctx)
+	return l
+}
+
+// Send queues a packet, it is not retained after Send returns.
+func (l *Link) Send(packet []byte) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.stats.Sent++
+	if l.chance(l.cfg.Loss) {
+		l.stats.Dropped++
+		return
+	}
+
+	copies := 1
+	if l.chance(l.cfg.Duplicate) {
+		l.stats.Duplicated++
+		copies++
+	}
+	for range copies {
+		if l.cfg.QueueLimit > 0 && len(l.queue) >= l.cfg.QueueLimit {
+			l.stats.Dropped++
+			continue
+		}
+		l.schedule(append([]byte(nil), packet...))
+	}
+
+	select {
+	case l.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (l *Link) chance(p float64) bool {
+	return p > 0 && l.rand.Float64() < p
+}
+
+func (l *Link) schedule(packet []byte) {
+	now := time.Now()
+	if l.busy.Before(now) {
+		l.busy = now
+	}
+	if l.cfg.Bandwidth > 0 {
+		l.busy = l.busy.Add(time.Duration(len(packet)) * time.Second / time.Duration(l.cfg.Bandwidth))
+	}
+
+	at := l.busy.Add(l.cfg.Delay)
+	if l.cfg.Jitter > 0 {
+		at = at.Add(time.Duration(l.rand.Int63n(int64(2*l.cfg.Jitter)+1)) - l.cfg.Jitter)
+	}
+	if l.chance(l.cfg.Reorder) {
+		l.stats.Reordered++
+		at = at.Add(l.cfg.ReorderDelay)
+	} else {
+		if at.Before(l.last) {
+			at = l.last
+		}
+		l.last = at
+	}
+
+	l.seq++
+	heap.Push(&l.queue, queued{at: at, seq: l.seq, packet: packet})
+}
+
+func (l *Link) run(ctx context.Context) {
+	defer close(l.done)
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		due, next := l.due(time.Now())
+		for _, p := range due {
+			l.deliver(p)
+		}
+		if len(due) > 0 {
+			continue
+		}
+
+		var wait <-chan time.Time
+		if !next.IsZero() {
+			timer.Reset(time.Until(next))
+			wait = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-l.wake:
+		case <-wait:
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+	}
+}
+
+func (l *Link) due(now time.Time) (due [][]byte, next time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for len(l.queue) > 0 && !l.queue[0].at.After(now) {
+		due = append(due, heap.Pop(&l.queue).(queued).packet)
+	}
+	l.stats.Delivered += uint64(len(due))
+	if len(l.queue) > 0 {
+		next = l.queue[0].at
+	}
+	return
+}
+
+func (l *Link) Stats() Stats {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.stats
+}
+
+// Close stops delivery, packets still queued are dropped.
+func (l *Link) Close() {
+	l.cancel()
+	<-l.done
+}
+
+type queued struct {
+	at     time.Time
+	seq    uint64
+	packet []byte
+}
+
+type packetQueue []queued
+
+func (q packetQueue) Len() int { return len(q) }
+func (q packetQueue) Less(i, j int) bool {
+	if q[i].at.Equal(q[j].at) {
+		return q[i].seq < q[j].seq
+	}
+	return q[i].at.Before(q[j].at)
+}
+func (q packetQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *packetQueue) Push(x any)   { *q = append(*q, x.(queued)) }
+func (q *packetQueue) Pop() any {
+	old := *q
+	x := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return x
+}
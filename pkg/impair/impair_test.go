@@ -0,0 +1,173 @@
+package impair
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/point-c/wg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+type collector struct {
+	lock sync.Mutex
+	got  []uint32
+}
+
+func (c *collector) deliver(b []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.got = append(c.got, binary.BigEndian.Uint32(b))
+}
+
+func (c *collector) packets() []uint32 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return slices.Clone(c.got)
+}
+
+func send(t *testing.T, cfg Config, n int) ([]uint32, Stats) {
+	var c collector
+	l := New(context.Background(), cfg, c.deliver)
+	defer l.Close()
+	for i := range n {
+		l.Send(binary.BigEndian.AppendUint32(nil, uint32(i)))
+	}
+	require.Eventually(t, func() bool {
+		st := l.Stats()
+		return st.Delivered == st.Sent-st.Dropped+st.Duplicated
+	}, 5*time.Second, time.Millisecond)
+	return c.packets(), l.Stats()
+}
+
+func TestLink(t *testing.T) {
+	t.Run("passthrough", func(t *testing.T) {
+		got, st := send(t, Config{}, 100)
+		require.Len(t, got, 100)
+		assert.True(t, slices.IsSorted(got))
+		assert.Equal(t, Stats{Sent: 100, Delivered: 100}, st)
+	})
+
+	t.Run("seeded", func(t *testing.T) {
+		cfg := Config{Seed: 7, Loss: 0.2, Duplicate: 0.1}
+		first, st := send(t, cfg, 1000)
+		second, _ := send(t, cfg, 1000)
+		assert.Equal(t, first, second)
+		assert.InDelta(t, 200, st.Dropped, 60)
+		assert.InDelta(t, 80, st.Duplicated, 40)
+
+		other, _ := send(t, Config{Seed: 8, Loss: 0.2, Duplicate: 0.1}, 1000)
+		assert.NotEqual(t, first, other)
+	})
+
+	t.Run("jitter keeps order", func(t *testing.T) {
+		got, _ := send(t, Config{Seed: 1, Delay: 2 * time.Millisecond, Jitter: 2 * time.Millisecond}, 200)
+		require.Len(t, got, 200)
+		assert.True(t, slices.IsSorted(got))
+	})
+
+	t.Run("reorder", func(t *testing.T) {
+		got, st := send(t, Config{Seed: 1, Reorder: 0.2, ReorderDelay: 5 * time.Millisecond}, 200)
+		require.Len(t, got, 200)
+		assert.False(t, slices.IsSorted(got))
+		assert.NotZero(t, st.Reordered)
+	})
+
+	t.Run("bandwidth", func(t *testing.T) {
+		start := time.Now()
+		got, _ := send(t, Config{Bandwidth: 4 * 1000}, 100)
+		require.Len(t, got, 100)
+		assert.GreaterOrEqual(t, time.Since(start), 90*time.Millisecond)
+	})
+
+	t.Run("queue limit", func(t *testing.T) {
+		got, st := send(t, Config{Delay: 10 * time.Millisecond, QueueLimit: 10}, 100)
+		assert.Len(t, got, 10)
+		assert.Equal(t, uint64(90), st.Dropped)
+	})
+}
+
+func bridge(t *testing.T, ctx context.Context, from, to *wg.Netstack, cfg Config) {
+	l := New(ctx, cfg, func(b []byte) { _, _ = to.Write([][]byte{b}, 0) })
+	t.Cleanup(l.Close)
+	go func() {
+		buf := [...][]byte{make([]byte, wg.DefaultMTU*2)}
+		var size [len(buf)]int
+		for ctx.Err() == nil {
+			n, err := from.Read(buf[:], size[:], 0)
+			if err != nil {
+				return
+			}
+			for i := range n {
+				l.Send(buf[i][:size[i]])
+			}
+		}
+	}()
+}
+
+func TestTCP(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{name: "clean"},
+		{name: "lossy", cfg: Config{Seed: 1, Loss: 0.05, Delay: time.Millisecond, Jitter: time.Millisecond}},
+		{name: "duplicates", cfg: Config{Seed: 2, Duplicate: 0.1}},
+		{name: "reordered", cfg: Config{Seed: 3, Reorder: 0.1, ReorderDelay: 3 * time.Millisecond}},
+		{name: "slow", cfg: Config{Seed: 4, Bandwidth: 1 << 20, QueueLimit: 64}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+
+			parent, err := wg.NewDefaultNetstack()
+			require.NoError(t, err)
+			defer parent.Close()
+			child, err := wg.NewDefaultNetstack()
+			require.NoError(t, err)
+			defer child.Close()
+			bridge(t, ctx, parent, child, tt.cfg)
+			bridge(t, ctx, child, parent, tt.cfg)
+
+			childAddr := &net.TCPAddr{IP: net.IPv4(1, 1, 1, 1).To4(), Port: 80}
+			ln, err := child.Net().Listen(childAddr)
+			require.NoError(t, err)
+			defer ln.Close()
+
+			want := make([]byte, 256<<10)
+			_, _ = rand.Read(want)
+			got := make(chan []byte, 1)
+			go func() {
+				conn, err := ln.Accept()
+				if err != nil {
+					got <- nil
+					return
+				}
+				defer conn.Close()
+				b, _ := io.ReadAll(conn)
+				got <- b
+			}()
+
+			conn, err := parent.Net().Dialer(net.IPv4(2, 2, 2, 2).To4(), 0).DialTCP(ctx, childAddr)
+			require.NoError(t, err)
+			_, err = io.Copy(conn, bytes.NewReader(want))
+			require.NoError(t, err)
+			require.NoError(t, conn.Close())
+
+			select {
+			case b := <-got:
+				assert.True(t, bytes.Equal(want, b), "received %d of %d bytes", len(b), len(want))
+			case <-ctx.Done():
+				t.Fatal(ctx.Err())
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package ipv4
+
+import (
+	"bytes"
+	"github.com/beetbasket/runner/pkg/matcher"
+	"github.com/stretchr/testify/assert"
+	"slices"
+	"testing"
+)
+
+const testPrefix = "3f1c1e9e-5b2c-4f7e-9a7d-1d2c3b4a5f6e-AbCdEfGhIj"
+
+var testKey = bytes.Repeat([]byte{7}, KeySize)
+
+type capture [][]byte
+
+func (c *capture) Write(bufs [][]byte, offset int) (int, error) {
+	for _, b := range bufs {
+		*c = append(*c, slices.Clone(b[offset:]))
+	}
+	return len(bufs), nil
+}
+
+// FuzzTunnel checks that regular output interleaved with packet lines comes out of the matcher and decoder
+// as the original regular bytes and packets in order, no matter how the stream is split into writes.
+func FuzzTunnel(f *testing.F) {
+	f.Add([]byte("hello\nworld\n"), []byte{0x45, 0, 0, 20, 1, 2, 3, 4}, []byte{0, 9, 0, 7}, []byte{3, 40, 1})
+	f.Add([]byte("no newline"), []byte{1, 2, 3}, []byte{7}, []byte{})
+	f.Add([]byte("\n\n3f1c \n"), []byte("packet"), []byte{0, 13, 0, 0}, []byte{1, 1, 1, 1, 1, 1})
+	f.Fuzz(func(t *testing.T, regular, payloads, layout, splits []byte) {
+		lines := bytes.SplitAfter(regular, []byte{'\n'})
+		for _, l := range lines {
+			if bytes.HasPrefix(l, []byte(testPrefix+" ")) {
+				t.Skip("regular line looks like a packet")
+			}
+		}
+
+		parent, child := NewSession(testKey, Parent), NewSession(testKey, Child)
+		var stream, wantRegular bytes.Buffer
+		var wantPackets [][]byte
+		for _, l := range layout {
+			if size := int(l >> 1); l&1 == 1 && size > 0 && len(payloads) > 0 {
+				size = min(size, len(payloads))
+				wantPackets = append(wantPackets, payloads[:size])
+				stream.Write(parent.Seal(testPrefix, payloads[:size]).Input())
+				payloads = payloads[size:]
+			} else if len(lines) > 0 && (len(lines) > 1 || bytes.HasSuffix(lines[0], []byte{'\n'})) {
+				// Packets may only follow complete lines, an unterminated last line goes at the end
+				stream.Write(lines[0])
+				wantRegular.Write(lines[0])
+				lines = lines[1:]
+			}
+		}
+		for _, l := range lines {
+			stream.Write(l)
+			wantRegular.Write(l)
+		}
+
+		var gotRegular bytes.Buffer
+		var gotPackets capture
+		m := matcher.New(testPrefix, &gotRegular, child.Decoder(&gotPackets))
+		data := stream.Bytes()
+		for _, s := range splits {
+			n := min(int(s), len(data))
+			_, _ = m.Write(data[:n])
+			data = data[n:]
+		}
+		_, _ = m.Write(data)
+		m.Flush()
+
+		assert.Equal(t, wantRegular.String(), gotRegular.String())
+		var wantBytes int
+		for _, p := range wantPackets {
+			wantBytes += len(p)
+		}
+		assert.Equal(t, Stats{Accepted: uint64(len(wantPackets)), AcceptedBytes: uint64(wantBytes)}, child.Stats())
+		assert.Equal(t, len(wantPackets), len(gotPackets))
+		for i := range min(len(wantPackets), len(gotPackets)) {
+			assert.Equal(t, wantPackets[i], gotPackets[i])
+		}
+	})
+}
+
+func TestSession(t *testing.T) {
+	line := func(in interface{ Input() []byte }) []byte {
+		return bytes.TrimPrefix(in.Input(), []byte(testPrefix+" "))
+	}
+
+	parent, child := NewSession(testKey, Parent), NewSession(testKey, Child)
+	first, second, third := line(parent.Seal(testPrefix, []byte("one"))), line(parent.Seal(testPrefix, []byte("two"))), line(parent.Seal(testPrefix, []byte("three")))
+	forged := NewSession(bytes.Repeat([]byte{8}, KeySize), Parent).Seal(testPrefix, []byte("evil"))
+	reflected := line(child.Seal(testPrefix, []byte("back")))
+	eof := line(parent.Seal(testPrefix, nil))
+
+	for _, tt := range []struct {
+		name string
+		line []byte
+		want []byte
+		err  error
+	}{
+		{name: "in order", line: first, want: []byte("one")},
+		{name: "skip ahead", line: third, want: []byte("three")},
+		{name: "late", line: second, want: []byte("two")},
+		{name: "replay", line: second, err: ErrReplayed},
+		{name: "wrong key", line: line(forged), err: ErrForged},
+		{name: "reflected", line: reflected, err: ErrForged},
+		{name: "not base64", line: []byte("4 !!! AAAA"), err: ErrMalformed},
+		{name: "missing mac", line: []byte("4 AAAA"), err: ErrMalformed},
+		{name: "zero seq", line: []byte("0 AAAA AAAA"), err: ErrMalformed},
+		{name: "extra space", line: []byte("4  AAAA AAAA"), err: ErrMalformed},
+		{name: "empty control frame", line: eof},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := child.Open(tt.line)
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	var packets capture
+	child.DecodePackets(&packets, append(append([]byte("garbage\n"), first...), '\n'))
+	assert.Equal(t, Stats{Sent: 1, SentBytes: 4, Malformed: 1, Replayed: 1}, child.Stats())
+}
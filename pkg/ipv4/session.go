@@ -0,0 +1,211 @@
+package ipv4
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/beetbasket/runner/pkg/message"
+	"github.com/beetbasket/runner/pkg/message/input"
+	"github.com/trymoose/errors"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	KeySize    = 32
+	macSize    = 16
+	windowSize = 64
+)
+
+var (
+	ErrMalformed = errors.New("malformed packet line")
+	ErrForged    = errors.New("packet line failed verification")
+	ErrReplayed  = errors.New("packet line replayed")
+)
+
+// Role keeps the two directions of a session apart, so lines cannot be reflected back to their sender.
+type Role byte
+
+const (
+	Parent Role = 'p'
+	Child  Role = 'c'
+)
+
+func (r Role) peer() Role {
+	if r == Parent {
+		return Child
+	}
+	return Parent
+}
+
+// Session seals outgoing packet lines with a sequence number and HMAC, and verifies incoming ones.
+type Session struct {
+	key  []byte
+	role Role
+	seq  atomic.Uint64
+
+	lock    sync.Mutex
+	highest uint64
+	window  uint64
+
+	sent, sentBytes, accepted, acceptedBytes atomic.Uint64
+	malformed, replayed, forged, lost        atomic.Uint64
+}
+
+type Stats struct {
+	Sent          uint64 `json:"sent"`
+	SentBytes     uint64 `json:"sent_bytes"`
+	Accepted      uint64 `json:"accepted"`
+	AcceptedBytes uint64 `json:"accepted_bytes"`
+	Malformed     uint64 `json:"malformed"`
+	Replayed      uint64 `json:"replayed"`
+	Forged        uint64 `json:"forged"`
+	Lost          uint64 `json:"lost"`
+}
+
+// DecodeFailures counts every line that was rejected.
+func (s Stats) DecodeFailures() uint64 {
+	return s.Malformed + s.Replayed + s.Forged
+}
+
+func GenerateKey() []byte {
+	key := make([]byte, KeySize)
+	_, _ = rand.Read(key)
+	return key
+}
+
+func NewSession(key []byte, role Role) *Session {
+	return &Session{key: key, role: role}
+}
+
+func (s *Session) Seal(prefix string, packet []byte) message.Input {
+	seq := s.seq.Add(1)
+	s.sent.Add(1)
+	s.sentBytes.Add(uint64(len(packet)))
+	return input.NewPacketInput(prefix, seq, packet, s.mac(s.role, seq, packet))
+}
+
+func (s *Session) mac(role Role, seq uint64, data []byte) []byte {
+	h := hmac.New(sha256.New, s.key)
+	var hdr [9]byte
+	hdr[0] = byte(role)
+	binary.BigEndian.PutUint64(hdr[1:], seq)
+	h.Write(hdr[:])
+	h.Write(data)
+	return h.Sum(nil)[:macSize]
+}
+
+// Open verifies a line of the form "seq data mac", with the prefix already removed, and returns the packet.
+// The packet is empty for control frames like the end of stdin.
+func (s *Session) Open(line []byte) ([]byte, error) {
+	fields := bytes.Split(line, []byte{' '})
+	if len(fields) != 3 {
+		return nil, ErrMalformed
+	}
+	seq, err := strconv.ParseUint(string(fields[0]), 10, 64)
+	if err != nil || seq == 0 {
+		return nil, ErrMalformed
+	}
+	data, err := base64.StdEncoding.AppendDecode(nil, fields[1])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	mac, err := base64.StdEncoding.AppendDecode(nil, fields[2])
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	if !hmac.Equal(mac, s.mac(s.role.peer(), seq, data)) {
+		return nil, ErrForged
+	} else if !s.accept(seq) {
+		return nil, ErrReplayed
+	}
+	return data, nil
+}
+
+// accept records seq in the sliding window, gaps count as lost until they arrive
+func (s *Session) accept(seq uint64) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if seq > s.highest {
+		shift := seq - s.highest
+		if shift >= windowSize {
+			s.window = 0
+		} else {
+			s.window <<= shift
+		}
+		s.window |= 1
+		s.lost.Add(shift - 1)
+		s.highest = seq
+		return true
+	}
+
+	diff := s.highest - seq
+	if diff >= windowSize || s.window&(1<<diff) != 0 {
+		return false
+	}
+	s.window |= 1 << diff
+	s.lost.Add(^uint64(0))
+	return true
+}
+
+func (s *Session) DecodePackets(ns PacketWriter, b []byte) {
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		packet, err := s.Open(line)
+		switch {
+		case errors.Is(err, ErrMalformed):
+			s.malformed.Add(1)
+		case errors.Is(err, ErrForged):
+			s.forged.Add(1)
+		case errors.Is(err, ErrReplayed):
+			s.replayed.Add(1)
+		default:
+			s.accepted.Add(1)
+			s.acceptedBytes.Add(uint64(len(packet)))
+			_, _ = ns.Write([][]byte{packet}, 0)
+			continue
+		}
+		slog.Warn("rejected packet line", slog.Any("error", err))
+	}
+}
+
+type decoder struct {
+	session *Session
+	ns      PacketWriter
+}
+
+// Decoder returns a writer that injects every verified packet line written to it into ns.
+func (s *Session) Decoder(ns PacketWriter) io.Writer {
+	return decoder{session: s, ns: ns}
+}
+
+func (d decoder) Write(b []byte) (int, error) {
+	d.session.DecodePackets(d.ns, b)
+	return len(b), nil
+}
+
+func (s *Session) Stats() Stats {
+	return Stats{
+		Sent:          s.sent.Load(),
+		SentBytes:     s.sentBytes.Load(),
+		Accepted:      s.accepted.Load(),
+		AcceptedBytes: s.acceptedBytes.Load(),
+		Malformed:     s.malformed.Load(),
+		Replayed:      s.replayed.Load(),
+		Forged:        s.forged.Load(),
+		Lost:          s.lost.Load(),
+	}
+}
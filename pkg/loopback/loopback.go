@@ -0,0 +1,198 @@
+package loopback
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"github.com/beetbasket/runner/pkg/ipv4"
+	"github.com/beetbasket/runner/pkg/matcher"
+	"github.com/beetbasket/runner/pkg/stdionet"
+	"github.com/beetbasket/runner/pkg/trace"
+	"github.com/google/uuid"
+	"github.com/point-c/wg"
+	"github.com/trymoose/errors"
+	"io"
+	"net"
+	"slices"
+	"sync"
+)
+
+// Loopback connects a parent endpoint to an in-process stdionet child through pipes, using the same framing as a Cmd.
+type Loopback struct {
+	child   *stdionet.StdioNet
+	ns      *wg.Netstack
+	session *ipv4.Session
+	prefix  string
+	address net.IP
+	cancel  func()
+
+	stdinLock sync.Mutex
+	stdin     *io.PipeWriter
+	stdout    *io.PipeWriter
+	output    lockedBuffer
+	errput    lockedBuffer
+
+	exit     chan struct{}
+	exitOnce sync.Once
+	code     int
+	close    sync.Once
+	closeErr error
+}
+
+func New(ctx context.Context) (*Loopback, error) {
+	ns, err := wg.NewDefaultNetstack()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	key := ipv4.GenerateKey()
+	childStdin, stdin := io.Pipe()
+	stdout, childStdout := io.Pipe()
+	l := Loopback{
+		ns:      ns,
+		session: ipv4.NewSession(key, ipv4.Parent),
+		prefix:  uuid.NewString(),
+		address: ipv4.GenerateRandomIPv4(),
+		cancel:  cancel,
+		stdin:   stdin,
+		stdout:  childStdout,
+		exit:    make(chan struct{}),
+	}
+
+	ev := stdionet.Env{
+		Prefix:  l.prefix,
+		Address: l.address,
+		Key:     base64.StdEncoding.EncodeToString(key),
+	}
+	// Pass the trace in ctx to the child like a Cmd does in TRACEPARENT
+	if sc, ok := trace.SpanContextFromContext(ctx); ok {
+		ev.TraceParent = sc.TraceParent()
+	}
+	l.child, err = stdionet.Open(ctx,
+		stdionet.WithEnv(ev),
+		stdionet.WithStdio(childStdin, childStdout, &l.errput),
+		stdionet.WithExit(l.exited),
+	)
+	if err != nil {
+		cancel()
+		return nil, errors.Join(err, ns.Close())
+	}
+
+	go l.readStdout(stdout)
+	go l.pipePackets(ctx)
+	return &l, nil
+}
+
+// Child is the child side of the loopback.
+func (l *Loopback) Child() *stdionet.StdioNet {
+	return l.child
+}
+
+func (l *Loopback) Address() net.IP {
+	return l.address
+}
+
+func (l *Loopback) Dial(ctx context.Context, addr *net.TCPAddr) (net.Conn, error) {
+	return l.ns.Net().Dialer(l.address, 0).DialTCP(ctx, addr)
+}
+
+func (l *Loopback) Listen(port uint16) (net.Listener, error) {
+	return l.ns.Net().Listen(&net.TCPAddr{
+		IP:   l.address,
+		Port: int(port),
+	})
+}
+
+// Write sends b to the child's stdin.
+func (l *Loopback) Write(b []byte) (int, error) {
+	l.stdinLock.Lock()
+	defer l.stdinLock.Unlock()
+	return l.stdin.Write(b)
+}
+
+// CloseInput ends the child's stdin, the tunnel keeps working.
+func (l *Loopback) CloseInput() error {
+	_, err := l.Write(l.session.Seal(l.prefix, nil).Input())
+	return err
+}
+
+// Stdout is everything the child wrote to stdout except packet lines.
+func (l *Loopback) Stdout() []byte {
+	return l.output.Bytes()
+}
+
+func (l *Loopback) Stderr() []byte {
+	return l.errput.Bytes()
+}
+
+// Exited is closed when the child calls Exit.
+func (l *Loopback) Exited() <-chan struct{} {
+	return l.exit
+}
+
+func (l *Loopback) ExitCode() int {
+	<-l.exit
+	return l.code
+}
+
+func (l *Loopback) exited(code int) {
+	l.exitOnce.Do(func() {
+		l.code = code
+		close(l.exit)
+	})
+}
+
+func (l *Loopback) Close() error {
+	l.close.Do(func() {
+		l.cancel()
+		l.closeErr = errors.Join(
+			l.child.Close(),
+			l.stdin.Close(),
+			l.stdout.Close(),
+			l.ns.Close(),
+		)
+	})
+	return l.closeErr
+}
+
+func (l *Loopback) readStdout(r io.Reader) {
+	mm := matcher.New(l.prefix, &l.output, l.session.Decoder(l.ns))
+	defer mm.Flush()
+	_, _ = io.Copy(mm, r)
+}
+
+func (l *Loopback) pipePackets(ctx context.Context) {
+	buf := [...][]byte{make([]byte, wg.DefaultMTU*2)}
+	var size [len(buf)]int
+	for ctx.Err() == nil {
+		n, err := l.ns.Read(buf[:], size[:], 0)
+		if err != nil {
+			return
+		}
+		for i, b := range buf[:n] {
+			if size[i] > 0 {
+				if _, err := l.Write(l.session.Seal(l.prefix, slices.Clone(b[:size[i]])).Input()); err != nil {
+					return
+				}
+			}
+		}
+	}
+}
+
+type lockedBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (lb *lockedBuffer) Write(b []byte) (int, error) {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+	return lb.buf.Write(b)
+}
+
+func (lb *lockedBuffer) Bytes() []byte {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+	return slices.Clone(lb.buf.Bytes())
+}
@@ -0,0 +1,152 @@
+package loopback
+
+import (
+	"context"
+	"github.com/beetbasket/runner/pkg/trace"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLoopback(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	l, err := New(ctx)
+	require.NoError(t, err)
+	defer l.Close()
+	child := l.Child()
+
+	t.Run("dial child", func(t *testing.T) {
+		ln, err := child.Listen(80)
+		require.NoError(t, err)
+		defer ln.Close()
+		go func() {
+			_ = http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { _, _ = io.Copy(w, r.Body) }))
+		}()
+
+		conn, err := l.Dial(ctx, &net.TCPAddr{IP: child.Address(), Port: 80})
+		require.NoError(t, err)
+		hc := http.Client{Transport: &http.Transport{DialContext: func(context.Context, string, string) (net.Conn, error) { return conn, nil }}}
+		resp, err := hc.Post("http://child", "text/plain", http.NoBody)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	})
+
+	t.Run("dial parent", func(t *testing.T) {
+		ln, err := l.Listen(81)
+		require.NoError(t, err)
+		defer ln.Close()
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			_, _ = io.Copy(conn, conn)
+		}()
+
+		conn, err := child.Dial(ctx, child.ParentAddrTCP(81))
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		var buf [4]byte
+		_, err = io.ReadFull(conn, buf[:])
+		require.NoError(t, err)
+		assert.Equal(t, "ping", string(buf[:]))
+	})
+
+	t.Run("stdio", func(t *testing.T) {
+		_, err := l.Write([]byte("from parent\n"))
+		require.NoError(t, err)
+		_, err = child.Stdout().Write([]byte("from child\n"))
+		require.NoError(t, err)
+		_, err = child.Stderr().Write([]byte("oops\n"))
+		require.NoError(t, err)
+
+		require.EventuallyWithT(t, func(t *assert.CollectT) {
+			assert.Equal(t, "from child\n", string(l.Stdout()))
+		}, time.Second, time.Millisecond)
+		assert.Equal(t, "oops\n", string(l.Stderr()))
+		b := make([]byte, len("from parent\n"))
+		_, err = io.ReadFull(child.Stdin(), b)
+		require.NoError(t, err)
+		assert.Equal(t, "from parent\n", string(b))
+	})
+
+	t.Run("prompt then dial", func(t *testing.T) {
+		_, err := child.Stdout().Write([]byte("prompt> "))
+		require.NoError(t, err)
+		require.EventuallyWithT(t, func(t *assert.CollectT) {
+			assert.Equal(t, "from child\nprompt> ", string(l.Stdout()))
+		}, time.Second, time.Millisecond)
+
+		ln, err := l.Listen(83)
+		require.NoError(t, err)
+		defer ln.Close()
+		go func() {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			_, _ = io.Copy(conn, conn)
+		}()
+		conn, err := child.Dial(ctx, child.ParentAddrTCP(83))
+		require.NoError(t, err, "packets should not wait for the prompt's line to end")
+		defer conn.Close()
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		var buf [4]byte
+		_, err = io.ReadFull(conn, buf[:])
+		require.NoError(t, err)
+
+		_, err = child.Stdout().Write([]byte("answer\n"))
+		require.NoError(t, err)
+		require.EventuallyWithT(t, func(t *assert.CollectT) {
+			assert.Equal(t, "from child\nprompt> \nanswer\n", string(l.Stdout()))
+		}, time.Second, time.Millisecond)
+	})
+
+	t.Run("close input", func(t *testing.T) {
+		_, err := l.Write([]byte("last line\n"))
+		require.NoError(t, err)
+		require.NoError(t, l.CloseInput())
+		b, err := io.ReadAll(child.Stdin())
+		require.NoError(t, err)
+		assert.Equal(t, "last line\n", string(b))
+
+		ln, err := l.Listen(82)
+		require.NoError(t, err)
+		defer ln.Close()
+		go func() {
+			if conn, err := ln.Accept(); err == nil {
+				_ = conn.Close()
+			}
+		}()
+		conn, err := child.Dial(ctx, child.ParentAddrTCP(82))
+		require.NoError(t, err, "tunnel should outlive stdin")
+		_ = conn.Close()
+	})
+
+	t.Run("trace", func(t *testing.T) {
+		spanCtx, span := trace.NewTracer(nil).Start(ctx, "parent")
+		traced, err := New(spanCtx)
+		require.NoError(t, err)
+		defer traced.Close()
+		sc, ok := trace.SpanContextFromContext(traced.Child().TraceContext(context.Background()))
+		require.True(t, ok)
+		assert.Equal(t, span.Context(), sc)
+	})
+
+	t.Run("exit", func(t *testing.T) {
+		child.Exit(4)
+		<-l.Exited()
+		assert.Equal(t, 4, l.ExitCode())
+	})
+}
@@ -3,164 +3,126 @@ package matcher
 import (
 	"bytes"
 	"io"
-	"slices"
 )
 
+// Matcher writes lines starting with prefix and a space to special, without the prefix, once the whole line has arrived.
+// Everything else goes to out as soon as it is known not to be a prefix.
+// Slices passed to the writers are only valid for the duration of the call.
 type Matcher struct {
-	parser  parse
-	out     bytes.Buffer
-	special bytes.Buffer
+	prefix       []byte
+	out, special io.Writer
+	state        matchState
+	matched      int
+	line         []byte
 }
 
 type Bytes interface {
 	~string | ~[]byte
 }
 
-func New[B Bytes](prefix B) *Matcher {
-	var m Matcher
-	m.parser = newParser([]byte(prefix), &m.out, &m.special)
-	return &m
-}
-
-func (m *Matcher) Write(b []byte) (n int, _ error) {
-	for _, bb := range b {
-		m.parser = m.parser.parse(bb)
-	}
-	return len(b), nil
-}
+type matchState int
 
-func (m *Matcher) ReadSpecial() []byte {
-	defer m.special.Reset()
-	return slices.Clone(m.special.Bytes())
-}
-
-func (m *Matcher) ReadOut() []byte {
-	defer m.out.Reset()
-	return slices.Clone(m.out.Bytes())
-}
-
-type parse interface {
-	parse(byte) parse
-}
+const (
+	matchStart matchState = iota
+	matchRegular
+	matchSpecial
+)
 
-func newParser(prefix []byte, out, special parseWriter) parse {
-	reg := &writeRegularParse{out: out}
-	if len(prefix) == 0 {
-		reg.head = reg
-		return reg
+func New[B Bytes](prefix B, out, special io.Writer) *Matcher {
+	m := Matcher{out: out, special: special}
+	if m.out == nil {
+		m.out = io.Discard
 	}
-
-	spec := &writeSpecialParse{out: special}
-	head := &prefixParse{
-		spaceParse: spaceParse{
-			regular: reg,
-			special: spec,
-			prefix:  prefix,
-			out:     out,
-		},
+	if m.special == nil {
+		m.special = io.Discard
 	}
-	head.head = head
-	reg.head = head
-	spec.head = head
-
-	curr := head
-	for i := range len(prefix[1:]) {
-		next := &prefixParse{
-			spaceParse: spaceParse{
-				head:    head,
-				regular: reg,
-				special: spec,
-				prefix:  prefix,
-				out:     out,
-			},
-			idx: i + 1,
-		}
-		curr.next = next
-		curr = next
-	}
-
-	curr.next = &spaceParse{
-		head:    head,
-		regular: reg,
-		special: spec,
-		prefix:  prefix,
-		out:     out,
+	if len(prefix) > 0 {
+		m.prefix = append([]byte(prefix), ' ')
 	}
-
-	return head
-}
-
-type spaceParse struct {
-	head    parse
-	regular parse
-	special parse
-	prefix  []byte
-	out     parseWriter
+	return &m
 }
 
-func (sp *spaceParse) parse(b byte) parse {
-	if b == ' ' {
-		return sp.special
+func (m *Matcher) Write(b []byte) (n int, _ error) {
+	if len(m.prefix) == 0 {
+		m.emit(b)
+		return len(b), nil
 	}
 
-	_, _ = sp.out.Write(sp.prefix)
-	_ = sp.out.WriteByte(b)
-	if b == '\n' {
-		return sp.head
+	// run is the start of the regular span that has not been written yet
+	run, i := 0, 0
+	for i < len(b) {
+		switch m.state {
+		case matchRegular:
+			j := bytes.IndexByte(b[i:], '\n')
+			if j < 0 {
+				i = len(b)
+				continue
+			}
+			i += j + 1
+			m.state = matchStart
+		case matchStart:
+			need := m.prefix[m.matched:]
+			k := commonPrefix(need, b[i:])
+			switch {
+			case k == len(need):
+				m.emit(b[run:i])
+				i += k
+				run = i
+				m.matched, m.state = 0, matchSpecial
+			case i+k == len(b):
+				m.emit(b[run:i])
+				m.matched += k
+				return len(b), nil
+			default:
+				// Only possible at the start of a write, the held prefix comes before this span
+				if m.matched > 0 {
+					m.emit(m.prefix[:m.matched])
+					m.matched = 0
+				}
+				m.state = matchRegular
+			}
+		case matchSpecial:
+			j := bytes.IndexByte(b[i:], '\n')
+			if j < 0 {
+				m.line = append(m.line, b[i:]...)
+				return len(b), nil
+			}
+			end := i + j + 1
+			if len(m.line) == 0 {
+				_, _ = m.special.Write(b[i:end])
+			} else {
+				m.line = append(m.line, b[i:end]...)
+				_, _ = m.special.Write(m.line)
+				m.line = m.line[:0]
+			}
+			i, run = end, end
+			m.state = matchStart
+		}
 	}
-	return sp.regular
-}
-
-type prefixParse struct {
-	spaceParse
-	next parse
-	idx  int
+	m.emit(b[run:])
+	return len(b), nil
 }
 
-func (pp *prefixParse) parse(b byte) parse {
-	if b == pp.prefix[pp.idx] {
-		return pp.next
-	}
-
-	_, _ = pp.out.Write(pp.prefix[:pp.idx])
-	_ = pp.out.WriteByte(b)
-
-	if b == '\n' {
-		return pp.head
+// Flush writes a partially matched prefix at the end of the stream to out.
+func (m *Matcher) Flush() {
+	if m.state == matchStart && m.matched > 0 {
+		m.emit(m.prefix[:m.matched])
+		m.matched = 0
 	}
-	return pp.regular
 }
 
-type parseWriter interface {
-	io.Writer
-	io.ByteWriter
-}
-
-type writeSpecialParse struct {
-	head parse
-	buf  bytes.Buffer
-	out  io.Writer
-}
-
-func (wsp *writeSpecialParse) parse(b byte) parse {
-	_ = wsp.buf.WriteByte(b)
-	if b == '\n' {
-		_, _ = wsp.buf.WriteTo(wsp.out)
-		wsp.buf.Reset()
-		return wsp.head
+func (m *Matcher) emit(b []byte) {
+	if len(b) > 0 {
+		_, _ = m.out.Write(b)
 	}
-	return wsp
-}
-
-type writeRegularParse struct {
-	head parse
-	out  io.ByteWriter
 }
 
-func (wrp *writeRegularParse) parse(b byte) parse {
-	_ = wrp.out.WriteByte(b)
-	if b == '\n' {
-		return wrp.head
+func commonPrefix(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := range n {
+		if a[i] != b[i] {
+			return i
+		}
 	}
-	return wrp
+	return n
 }
@@ -1,7 +1,9 @@
 package matcher
 
 import (
+	"bytes"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"testing"
 )
 
@@ -55,14 +57,24 @@ func TestMatcher(t *testing.T) {
 			output:  newA("fo", "o bar ", "baz\n"),
 			special: newA("", "", "foo bar baz\n"),
 		},
+		{
+			name:    "write ends after prefix",
+			prefix:  "aaa",
+			input:   newA("aaa ", "foo\n"),
+			output:  newA("", ""),
+			special: newA("", "foo\n"),
+		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
-			m := New(tt.prefix)
+			var outBuf, specBuf bytes.Buffer
+			m := New(tt.prefix, &outBuf, &specBuf)
 			var out, spec []string
 			for _, b := range tt.input {
 				_, _ = m.Write([]byte(b))
-				out = append(out, string(m.ReadOut()))
-				spec = append(spec, string(m.ReadSpecial()))
+				out = append(out, outBuf.String())
+				spec = append(spec, specBuf.String())
+				outBuf.Reset()
+				specBuf.Reset()
 			}
 
 			assert.Equal(t, tt.output, out)
@@ -78,3 +90,65 @@ func newA[B Bytes](d ...B) []string {
 	}
 	return a
 }
+
+func FuzzMatcher(f *testing.F) {
+	f.Add("aaa", []byte("foo bar baz\naaa foo bar baz\n"), []byte{2, 5})
+	f.Add("aaa", []byte("aa\naaa\naaa \naaaa b\n"), []byte{1, 1, 1, 1})
+	f.Add("", []byte("aaa foo\n"), []byte{3})
+	f.Add("p", []byte("p x\np"), []byte{})
+	f.Fuzz(func(t *testing.T, prefix string, data []byte, splits []byte) {
+		var outBuf, specBuf bytes.Buffer
+		m := New(prefix, &outBuf, &specBuf)
+		legacy := newLegacy(prefix)
+		for _, chunk := range split(data, splits) {
+			_, _ = m.Write(chunk)
+			_, _ = legacy.Write(chunk)
+			if !assert.Equal(t, string(legacy.ReadOut()), outBuf.String()) ||
+				!assert.Equal(t, string(legacy.ReadSpecial()), specBuf.String()) {
+				return
+			}
+			outBuf.Reset()
+			specBuf.Reset()
+		}
+	})
+}
+
+func split(data, sizes []byte) (chunks [][]byte) {
+	for _, s := range sizes {
+		n := min(int(s), len(data))
+		chunks, data = append(chunks, data[:n]), data[n:]
+	}
+	return append(chunks, data)
+}
+
+func benchInput() []byte {
+	var buf bytes.Buffer
+	for i := range 1000 {
+		if i%4 == 0 {
+			buf.WriteString("3f1c1e9e-5b2c-4f7e-9a7d-1d2c3b4a5f6e-AbCdEfGhIj RQAAPAAAQABABgAAwKgAAcCoAAIAUABQAAAAAAAAAABQAgAAAAAAAA==\n")
+		} else {
+			buf.WriteString("time=2024-01-01T00:00:00Z level=INFO msg=\"regular application output\" n=42\n")
+		}
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkMatcher(b *testing.B) {
+	data := benchInput()
+	m := New("3f1c1e9e-5b2c-4f7e-9a7d-1d2c3b4a5f6e-AbCdEfGhIj", io.Discard, io.Discard)
+	b.SetBytes(int64(len(data)))
+	for range b.N {
+		_, _ = m.Write(data)
+	}
+}
+
+func BenchmarkLegacyMatcher(b *testing.B) {
+	data := benchInput()
+	m := newLegacy("3f1c1e9e-5b2c-4f7e-9a7d-1d2c3b4a5f6e-AbCdEfGhIj")
+	b.SetBytes(int64(len(data)))
+	for range b.N {
+		_, _ = m.Write(data)
+		_ = m.ReadOut()
+		_ = m.ReadSpecial()
+	}
+}
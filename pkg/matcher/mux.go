@@ -0,0 +1,121 @@
+package matcher
+
+import (
+	"bytes"
+	"io"
+)
+
+// Mux splits lines starting with any of its registered prefixes followed by a space to that prefix's sink.
+// Everything else is passed to the regular writer as soon as it is known not to be a prefix.
+type Mux struct {
+	out      io.Writer
+	channels []channel
+	state    muxState
+	pending  []byte
+	line     []byte
+	special  *channel
+	regular  []byte
+}
+
+type channel struct {
+	prefix []byte
+	sink   io.Writer
+}
+
+type muxState int
+
+const (
+	muxStart muxState = iota
+	muxRegular
+	muxSpecial
+)
+
+func NewMux(out io.Writer) *Mux {
+	if out == nil {
+		out = io.Discard
+	}
+	return &Mux{out: out}
+}
+
+// Handle routes lines starting with prefix to sink. It must not be called concurrently with Write.
+func (m *Mux) Handle(prefix string, sink io.Writer) {
+	if len(prefix) == 0 {
+		return
+	}
+	for i := range m.channels {
+		if string(m.channels[i].prefix[:len(m.channels[i].prefix)-1]) == prefix {
+			m.channels[i].sink = sink
+			return
+		}
+	}
+	m.channels = append(m.channels, channel{
+		prefix: append([]byte(prefix), ' '),
+		sink:   sink,
+	})
+}
+
+func (m *Mux) Write(b []byte) (n int, _ error) {
+	for _, c := range b {
+		switch m.state {
+		case muxRegular:
+			m.regular = append(m.regular, c)
+			if c == '\n' {
+				m.state = muxStart
+			}
+		case muxSpecial:
+			m.line = append(m.line, c)
+			if c == '\n' {
+				m.flush()
+				_, _ = m.special.sink.Write(m.line)
+				m.line, m.special, m.state = m.line[:0], nil, muxStart
+			}
+		case muxStart:
+			m.pending = append(m.pending, c)
+			if ch, partial := m.match(); ch != nil {
+				m.pending, m.special, m.state = m.pending[:0], ch, muxSpecial
+			} else if !partial {
+				m.regular = append(m.regular, m.pending...)
+				m.pending = m.pending[:0]
+				if c != '\n' {
+					m.state = muxRegular
+				}
+			}
+		}
+	}
+	m.flush()
+	return len(b), nil
+}
+
+// Flush writes a partially matched prefix at the end of the stream to out, and an unterminated special line to its sink.
+func (m *Mux) Flush() {
+	switch m.state {
+	case muxStart:
+		m.regular = append(m.regular, m.pending...)
+		m.pending = m.pending[:0]
+	case muxSpecial:
+		if len(m.line) > 0 {
+			_, _ = m.special.sink.Write(m.line)
+		}
+		m.line, m.special = m.line[:0], nil
+	}
+	m.state = muxStart
+	m.flush()
+}
+
+func (m *Mux) flush() {
+	if len(m.regular) > 0 {
+		_, _ = m.out.Write(m.regular)
+		m.regular = m.regular[:0]
+	}
+}
+
+func (m *Mux) match() (_ *channel, partial bool) {
+	for i, ch := range m.channels {
+		if bytes.Equal(ch.prefix, m.pending) {
+			return &m.channels[i], true
+		} else if bytes.HasPrefix(ch.prefix, m.pending) {
+			partial = true
+		}
+	}
+	return nil, partial
+}
@@ -0,0 +1,128 @@
+package matcher
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMux(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		prefixes []string
+		input    []string
+		output   []string
+		special  map[string][]string
+	}{
+		{
+			name:     "no channels",
+			prefixes: nil,
+			input:    newA("pkt foo\n", "bar"),
+			output:   newA("pkt foo\n", "bar"),
+			special:  map[string][]string{},
+		},
+		{
+			name:     "two channels",
+			prefixes: newA("pkt", "rpc"),
+			input:    newA("foo\npkt aaa\nrpc bbb\nbar\n"),
+			output:   newA("foo\nbar\n"),
+			special: map[string][]string{
+				"pkt": newA("aaa\n"),
+				"rpc": newA("bbb\n"),
+			},
+		},
+		{
+			name:     "two channels broken",
+			prefixes: newA("pkt", "rpc"),
+			input:    newA("fo", "o\npk", "t aa", "a\nr", "pc bbb\nb", "ar\n"),
+			output:   newA("fo", "o\n", "", "", "b", "ar\n"),
+			special: map[string][]string{
+				"pkt": newA("", "", "", "aaa\n", "", ""),
+				"rpc": newA("", "", "", "", "bbb\n", ""),
+			},
+		},
+		{
+			name:     "prefix of prefix",
+			prefixes: newA("p", "pp"),
+			input:    newA("p a\npp b\nppp c\n"),
+			output:   newA("ppp c\n"),
+			special: map[string][]string{
+				"p":  newA("a\n"),
+				"pp": newA("b\n"),
+			},
+		},
+		{
+			name:     "prefix without space",
+			prefixes: newA("pkt", "rpc"),
+			input:    newA("pkt", "x\nrpc\n", "rp", "c", " y\n"),
+			output:   newA("", "pktx\nrpc\n", "", "", ""),
+			special: map[string][]string{
+				"pkt": newA("", "", "", "", ""),
+				"rpc": newA("", "", "", "", "y\n"),
+			},
+		},
+		{
+			name:     "prefix mid line",
+			prefixes: newA("pkt"),
+			input:    newA("foo pkt bar\n"),
+			output:   newA("foo pkt bar\n"),
+			special: map[string][]string{
+				"pkt": newA(""),
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			m := NewMux(&out)
+			sinks := map[string]*bytes.Buffer{}
+			for _, p := range tt.prefixes {
+				sinks[p] = new(bytes.Buffer)
+				m.Handle(p, sinks[p])
+			}
+
+			var gotOut []string
+			gotSpecial := map[string][]string{}
+			for _, b := range tt.input {
+				_, _ = m.Write([]byte(b))
+				gotOut = append(gotOut, out.String())
+				out.Reset()
+				for p, s := range sinks {
+					gotSpecial[p] = append(gotSpecial[p], s.String())
+					s.Reset()
+				}
+			}
+
+			assert.Equal(t, tt.output, gotOut)
+			assert.Equal(t, tt.special, gotSpecial)
+		})
+	}
+}
+
+func TestMuxFlush(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		input   string
+		output  string
+		special string
+	}{
+		{name: "partial prefix", input: "foo\npk", output: "foo\npk"},
+		{name: "partial line", input: "pkt aaa", special: "aaa"},
+		{name: "regular line", input: "foo", output: "foo"},
+		{name: "nothing held", input: "pkt aaa\n", special: "aaa\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var out, sink bytes.Buffer
+			m := NewMux(&out)
+			m.Handle("pkt", &sink)
+			_, _ = m.Write([]byte(tt.input))
+			m.Flush()
+			assert.Equal(t, tt.output, out.String())
+			assert.Equal(t, tt.special, sink.String())
+
+			out.Reset()
+			_, _ = m.Write([]byte("pkt bbb\n"))
+			assert.Empty(t, out.String(), "flush should reset to the start of a line")
+			assert.Equal(t, tt.special+"bbb\n", sink.String())
+		})
+	}
+}
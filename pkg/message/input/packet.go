@@ -4,23 +4,30 @@ import (
 	"encoding/base64"
 	"github.com/beetbasket/runner/pkg/message"
 	"github.com/beetbasket/runner/pkg/message/internal/kind/input"
+	"strconv"
 )
 
 type PacketInput struct {
 	message.BaseMessageKind[input.Packet]
 	Prefix string
+	Seq    uint64       `json:"seq"`
 	Data   message.Data `json:"data"`
+	MAC    message.Data `json:"mac"`
 }
 
 func (pi PacketInput) Input() []byte {
-	data := base64.StdEncoding.AppendEncode(append([]byte(pi.Prefix), ' '), pi.Data)
+	data := strconv.AppendUint(append([]byte(pi.Prefix), ' '), pi.Seq, 10)
+	data = base64.StdEncoding.AppendEncode(append(data, ' '), pi.Data)
+	data = base64.StdEncoding.AppendEncode(append(data, ' '), pi.MAC)
 	return append(data, '\n')
 }
 
-func NewPacketInput[D message.DataLike](prefix string, data D) message.Input {
+func NewPacketInput[D message.DataLike](prefix string, seq uint64, data, mac D) message.Input {
 	return PacketInput{
 		BaseMessageKind: message.NewBaseMessageKind[input.Packet](),
 		Prefix:          prefix,
+		Seq:             seq,
 		Data:            []byte(data),
+		MAC:             []byte(mac),
 	}
 }
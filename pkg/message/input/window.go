@@ -0,0 +1,24 @@
+package input
+
+import (
+	"github.com/beetbasket/runner/pkg/message"
+	"github.com/beetbasket/runner/pkg/message/internal/kind/input"
+)
+
+type WindowSizeInput struct {
+	message.BaseMessageKind[input.WindowSize]
+	Rows uint16 `json:"rows"`
+	Cols uint16 `json:"cols"`
+}
+
+func (WindowSizeInput) Input() []byte {
+	return nil
+}
+
+func NewWindowSizeInput(rows, cols uint16) message.Input {
+	return WindowSizeInput{
+		BaseMessageKind: message.NewBaseMessageKind[input.WindowSize](),
+		Rows:            rows,
+		Cols:            cols,
+	}
+}
@@ -5,11 +5,13 @@ import (
 )
 
 type (
-	Text   = kind.Kind[text]
-	Packet = kind.Kind[packet]
+	Text       = kind.Kind[text]
+	Packet     = kind.Kind[packet]
+	WindowSize = kind.Kind[windowSize]
 )
 
 type (
-	text   struct{}
-	packet struct{}
+	text       struct{}
+	packet     struct{}
+	windowSize struct{}
 )
@@ -9,6 +9,7 @@ type (
 	Stdio  = kind.Kind[stdio]
 	Start  = kind.Kind[start]
 	Exit   = kind.Kind[exit]
+	Stats  = kind.Kind[stats]
 )
 
 type (
@@ -16,4 +17,5 @@ type (
 	stdio  struct{}
 	start  struct{}
 	exit   struct{}
+	stats  struct{}
 )
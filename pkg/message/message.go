@@ -39,8 +39,15 @@ type DataLike interface {
 	~string | ~[]byte
 }
 
+// Kinded is implemented by messages with a kind, see output.KindOf.
+type Kinded interface {
+	KindName() string
+}
+
 func (bm BaseMessage) Message() BaseMessage { return bm }
 
+func (JSONKind[S]) KindName() string { return (*new(S)).String() }
+
 func NewBaseMessage() BaseMessage {
 	return BaseMessage{Time: time.Now()}
 }
@@ -0,0 +1,23 @@
+package output
+
+import (
+	"github.com/beetbasket/runner/pkg/message"
+)
+
+// ChildMessage tags a message from one child of a runner.Group.
+type ChildMessage struct {
+	ID  string          `json:"id"`
+	Msg message.Message `json:"message"`
+}
+
+func (cm ChildMessage) Message() message.BaseMessage {
+	return cm.Msg.Message()
+}
+
+func (cm ChildMessage) KindName() string {
+	return KindOf(cm.Msg)
+}
+
+func NewChildMessage(id string, msg message.Message) message.Message {
+	return ChildMessage{ID: id, Msg: msg}
+}
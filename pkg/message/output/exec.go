@@ -11,17 +11,29 @@ type (
 	}
 	ExitMessage struct {
 		message.BaseMessageKind[output.Exit]
-		Code int `json:"code"`
+		Code   int        `json:"code"`
+		Reason ExitReason `json:"reason,omitempty"`
 	}
 )
 
+type ExitReason string
+
+const (
+	ExitMaxRuntime     ExitReason = "max_runtime"
+	ExitIdleTimeout    ExitReason = "idle_timeout"
+	ExitStartupTimeout ExitReason = "startup_timeout"
+	// ExitBrokenPipe is a pipeline stage stopped because a later stage exited.
+	ExitBrokenPipe ExitReason = "broken_pipe"
+)
+
 func NewStartMessage() message.Message {
 	return StartMessage{BaseMessageKind: message.NewBaseMessageKind[output.Start]()}
 }
 
-func NewExitMessage(code int) message.Message {
+func NewExitMessage(code int, reason ExitReason) message.Message {
 	return ExitMessage{
 		BaseMessageKind: message.NewBaseMessageKind[output.Exit](),
 		Code:            code,
+		Reason:          reason,
 	}
 }
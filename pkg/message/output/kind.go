@@ -0,0 +1,26 @@
+package output
+
+import (
+	"github.com/beetbasket/runner/pkg/message"
+	"github.com/beetbasket/runner/pkg/message/internal/kind/output"
+	"github.com/beetbasket/runner/pkg/message/internal/kind/stdio"
+)
+
+// Kind names of output messages, stdio messages are named after their stream.
+var (
+	KindStart  = output.Start{}.String()
+	KindExit   = output.Exit{}.String()
+	KindStats  = output.Stats{}.String()
+	KindPacket = output.Packet{}.String()
+	KindStdout = stdio.Stdout{}.String()
+	KindStderr = stdio.Stderr{}.String()
+	KindStdin  = stdio.Stdin{}.String()
+)
+
+// KindOf returns the kind name of msg, or an empty string if it has none.
+func KindOf(msg message.Message) string {
+	if k, ok := msg.(message.Kinded); ok {
+		return k.KindName()
+	}
+	return ""
+}
@@ -0,0 +1,22 @@
+package output
+
+import (
+	"github.com/beetbasket/runner/pkg/message"
+)
+
+type StageMessage struct {
+	Stage int             `json:"stage"`
+	Msg   message.Message `json:"message"`
+}
+
+func (sm StageMessage) Message() message.BaseMessage {
+	return sm.Msg.Message()
+}
+
+func (sm StageMessage) KindName() string {
+	return KindOf(sm.Msg)
+}
+
+func NewStageMessage(stage int, msg message.Message) message.Message {
+	return StageMessage{Stage: stage, Msg: msg}
+}
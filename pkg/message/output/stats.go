@@ -0,0 +1,19 @@
+package output
+
+import (
+	"github.com/beetbasket/runner/pkg/message"
+	"github.com/beetbasket/runner/pkg/message/internal/kind/output"
+	"github.com/beetbasket/runner/pkg/stats"
+)
+
+type StatsMessage struct {
+	message.BaseMessageKind[output.Stats]
+	Stats stats.Tunnel `json:"stats"`
+}
+
+func NewStatsMessage(s stats.Tunnel) message.Message {
+	return StatsMessage{
+		BaseMessageKind: message.NewBaseMessageKind[output.Stats](),
+		Stats:           s,
+	}
+}
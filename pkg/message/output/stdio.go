@@ -31,6 +31,9 @@ func newStdioMessage[K fmt.Stringer, D message.DataLike](data D) StdioMessage[K]
 	}
 }
 
+// KindName is the stream, stdout, stderr or stdin.
+func (StdioMessage[K]) KindName() string { return (*new(K)).String() }
+
 type StdioLike interface {
 	StderrMessage | StdoutMessage | StdinMessage
 }
@@ -0,0 +1,189 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"github.com/beetbasket/runner/pkg/message"
+	"github.com/beetbasket/runner/pkg/message/output"
+	"github.com/beetbasket/runner/pkg/stats"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// Source is satisfied by runner.Cmd.
+type Source interface {
+	Output(ctx context.Context) <-chan message.Message
+	Stats() stats.Tunnel
+}
+
+// Registry collects metrics for the children it tracks and serves them in the Prometheus text format.
+type Registry struct {
+	lock     sync.Mutex
+	names    map[string]struct{}
+	live     map[Source]struct{}
+	starts   uint64
+	restarts uint64
+	exits    map[int]uint64
+	stdio    map[string]uint64
+	// Tunnel counters of children that have exited
+	done stats.Tunnel
+}
+
+func New() *Registry {
+	return &Registry{
+		names: make(map[string]struct{}),
+		live:  make(map[Source]struct{}),
+		exits: make(map[int]uint64),
+		stdio: make(map[string]uint64),
+	}
+}
+
+// Track observes src until it exits or ctx is done. Tracking a name again counts as a restart.
+// It should be called before the child is started so the start is not missed.
+func (r *Registry) Track(ctx context.Context, name string, src Source) {
+	r.lock.Lock()
+	if _, ok := r.names[name]; ok {
+		r.restarts++
+	}
+	r.names[name] = struct{}{}
+	r.lock.Unlock()
+
+	msgs := src.Output(ctx)
+	go func() {
+		// Stop counting src as running if ctx ends before its exit message
+		defer func() {
+			r.lock.Lock()
+			r.retire(src)
+			r.lock.Unlock()
+		}()
+		for msg := range msgs {
+			r.observe(src, msg)
+		}
+	}()
+}
+
+// retire moves the tunnel stats of src to the exited total, r.lock must be held
+func (r *Registry) retire(src Source) {
+	if _, ok := r.live[src]; ok {
+		delete(r.live, src)
+		addTunnel(&r.done, src.Stats())
+	}
+}
+
+func (r *Registry) observe(src Source, msg message.Message) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	switch msg := msg.(type) {
+	case output.StartMessage:
+		r.starts++
+		r.live[src] = struct{}{}
+	case output.ExitMessage:
+		r.exits[msg.Code]++
+		r.retire(src)
+	case output.StdoutMessage:
+		r.stdio["stdout"] += uint64(len(msg.Data))
+	case output.StderrMessage:
+		r.stdio["stderr"] += uint64(len(msg.Data))
+	case output.StdinMessage:
+		r.stdio["stdin"] += uint64(len(msg.Data))
+	}
+}
+
+func addTunnel(dst *stats.Tunnel, src stats.Tunnel) {
+	dst.Packets.Sent += src.Packets.Sent
+	dst.Packets.SentBytes += src.Packets.SentBytes
+	dst.Packets.Accepted += src.Packets.Accepted
+	dst.Packets.AcceptedBytes += src.Packets.AcceptedBytes
+	dst.Packets.Malformed += src.Packets.Malformed
+	dst.Packets.Replayed += src.Packets.Replayed
+	dst.Packets.Forged += src.Packets.Forged
+	dst.Packets.Lost += src.Packets.Lost
+}
+
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = r.WriteTo(w)
+}
+
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.lock.Lock()
+	tunnel := r.done
+	for src := range r.live {
+		addTunnel(&tunnel, src.Stats())
+	}
+
+	var mw metricWriter
+	mw.metric("runner_children_running", "gauge", "Children currently running.", sample{value: uint64(len(r.live))})
+	mw.metric("runner_starts_total", "counter", "Children started.", sample{value: r.starts})
+	mw.metric("runner_restarts_total", "counter", "Children started again under a name already tracked.", sample{value: r.restarts})
+	var exits []sample
+	for _, code := range slices.Sorted(maps.Keys(r.exits)) {
+		exits = append(exits, sample{labels: [][2]string{{"code", strconv.Itoa(code)}}, value: r.exits[code]})
+	}
+	mw.metric("runner_exits_total", "counter", "Children exited by exit code.", exits...)
+	var stdio []sample
+	for _, stream := range slices.Sorted(maps.Keys(r.stdio)) {
+		stdio = append(stdio, sample{labels: [][2]string{{"stream", stream}}, value: r.stdio[stream]})
+	}
+	mw.metric("runner_stdio_bytes_total", "counter", "Bytes of stdio exchanged with children.", stdio...)
+	r.lock.Unlock()
+
+	mw.metric("runner_tunnel_packets_total", "counter", "Packets through the stdio tunnel.",
+		sample{labels: [][2]string{{"direction", "in"}}, value: tunnel.Packets.Accepted},
+		sample{labels: [][2]string{{"direction", "out"}}, value: tunnel.Packets.Sent},
+	)
+	mw.metric("runner_tunnel_bytes_total", "counter", "Packet bytes through the stdio tunnel.",
+		sample{labels: [][2]string{{"direction", "in"}}, value: tunnel.Packets.AcceptedBytes},
+		sample{labels: [][2]string{{"direction", "out"}}, value: tunnel.Packets.SentBytes},
+	)
+	mw.metric("runner_tunnel_decode_errors_total", "counter", "Packet lines rejected by reason.",
+		sample{labels: [][2]string{{"reason", "forged"}}, value: tunnel.Packets.Forged},
+		sample{labels: [][2]string{{"reason", "malformed"}}, value: tunnel.Packets.Malformed},
+		sample{labels: [][2]string{{"reason", "replayed"}}, value: tunnel.Packets.Replayed},
+	)
+	mw.metric("runner_tunnel_packets_lost", "gauge", "Packets missing from the sequence, late ones are removed when they arrive.",
+		sample{value: tunnel.Packets.Lost},
+	)
+	return mw.WriteTo(w)
+}
+
+type sample struct {
+	labels [][2]string
+	value  uint64
+}
+
+type metricWriter struct {
+	buf []byte
+}
+
+func (mw *metricWriter) metric(name, typ, help string, samples ...sample) {
+	mw.buf = fmt.Appendf(mw.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	for _, s := range samples {
+		mw.buf = append(mw.buf, name...)
+		for i, l := range s.labels {
+			if i == 0 {
+				mw.buf = append(mw.buf, '{')
+			} else {
+				mw.buf = append(mw.buf, ',')
+			}
+			mw.buf = append(mw.buf, l[0]...)
+			mw.buf = append(mw.buf, '=')
+			mw.buf = strconv.AppendQuote(mw.buf, l[1])
+		}
+		if len(s.labels) > 0 {
+			mw.buf = append(mw.buf, '}')
+		}
+		mw.buf = append(mw.buf, ' ')
+		mw.buf = strconv.AppendUint(mw.buf, s.value, 10)
+		mw.buf = append(mw.buf, '\n')
+	}
+}
+
+func (mw *metricWriter) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(mw.buf)
+	return int64(n), err
+}
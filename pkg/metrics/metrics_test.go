@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"context"
+	"github.com/beetbasket/runner/pkg/ipv4"
+	"github.com/beetbasket/runner/pkg/message"
+	"github.com/beetbasket/runner/pkg/message/output"
+	"github.com/beetbasket/runner/pkg/stats"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeSource struct {
+	msgs  chan message.Message
+	stats stats.Tunnel
+}
+
+func (fs *fakeSource) Output(context.Context) <-chan message.Message { return fs.msgs }
+func (fs *fakeSource) Stats() stats.Tunnel                           { return fs.stats }
+
+func TestRegistry(t *testing.T) {
+	r := New()
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	first := &fakeSource{msgs: make(chan message.Message), stats: stats.Tunnel{Packets: ipv4.Stats{Sent: 3, SentBytes: 300, Accepted: 2, AcceptedBytes: 120, Forged: 1}}}
+	second := &fakeSource{msgs: make(chan message.Message), stats: stats.Tunnel{Packets: ipv4.Stats{Sent: 1, SentBytes: 60, Malformed: 4}}}
+	r.Track(context.Background(), "worker", first)
+	first.msgs <- output.NewStartMessage()
+	first.msgs <- output.NewStdioMessage[output.StdoutMessage]("hello\n")
+	first.msgs <- output.NewStdioMessage[output.StderrMessage]("oops\n")
+	first.msgs <- output.NewExitMessage(2, "")
+	close(first.msgs)
+
+	r.Track(context.Background(), "worker", second)
+	second.msgs <- output.NewStartMessage()
+	second.msgs <- output.NewStdioMessage[output.StdinMessage]("in")
+
+	scrape := func() string {
+		resp, err := http.Get(srv.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", resp.Header.Get("Content-Type"))
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(b)
+	}
+
+	want := []string{
+		"runner_children_running 1",
+		"runner_starts_total 2",
+		"runner_restarts_total 1",
+		`runner_exits_total{code="2"} 1`,
+		`runner_stdio_bytes_total{stream="stderr"} 5`,
+		`runner_stdio_bytes_total{stream="stdin"} 2`,
+		`runner_stdio_bytes_total{stream="stdout"} 6`,
+		`runner_tunnel_packets_total{direction="in"} 2`,
+		`runner_tunnel_packets_total{direction="out"} 4`,
+		`runner_tunnel_bytes_total{direction="out"} 360`,
+		`runner_tunnel_decode_errors_total{reason="forged"} 1`,
+		`runner_tunnel_decode_errors_total{reason="malformed"} 4`,
+		"# TYPE runner_exits_total counter",
+	}
+	assert.EventuallyWithT(t, func(t *assert.CollectT) {
+		body := scrape()
+		for _, line := range want {
+			assert.Contains(t, strings.Split(body, "\n"), line)
+		}
+	}, time.Second, 10*time.Millisecond)
+}
+
+type ctxSource struct {
+	fakeSource
+}
+
+func (cs *ctxSource) Output(ctx context.Context) <-chan message.Message {
+	out := make(chan message.Message)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg := <-cs.msgs:
+				out <- msg
+			}
+		}
+	}()
+	return out
+}
+
+func TestRegistryUntrack(t *testing.T) {
+	r := New()
+	ctx, cancel := context.WithCancel(context.Background())
+	src := &ctxSource{fakeSource{msgs: make(chan message.Message), stats: stats.Tunnel{Packets: ipv4.Stats{Sent: 5, Lost: 2}}}}
+	r.Track(ctx, "worker", src)
+	src.msgs <- output.NewStartMessage()
+	cancel()
+
+	assert.EventuallyWithT(t, func(t *assert.CollectT) {
+		var sb strings.Builder
+		_, err := r.WriteTo(&sb)
+		assert.NoError(t, err)
+		lines := strings.Split(sb.String(), "\n")
+		assert.Contains(t, lines, "runner_children_running 0")
+		assert.Contains(t, lines, `runner_tunnel_packets_total{direction="out"} 5`)
+		assert.Contains(t, lines, "runner_tunnel_packets_lost 2")
+	}, time.Second, time.Millisecond)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	assert.Empty(t, r.live)
+}
@@ -0,0 +1,122 @@
+package pcap
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+)
+
+type Direction uint32
+
+const (
+	Inbound  Direction = 1
+	Outbound Direction = 2
+)
+
+const (
+	blockSectionHeader     = 0x0A0D0D0A
+	blockInterface         = 0x00000001
+	blockEnhancedPacket    = 0x00000006
+	byteOrderMagic         = 0x1A2B3C4D
+	linkTypeRaw            = 101
+	optionEndOfOptions     = 0
+	optionEnhancedPacketFl = 2
+)
+
+// Writer writes raw ip packets to a pcapng stream with their direction, it is safe for concurrent use.
+type Writer struct {
+	lock sync.Mutex
+	w    io.Writer
+	buf  []byte
+}
+
+func NewWriter(w io.Writer) (*Writer, error) {
+	pw := Writer{w: w}
+
+	// Section header, version 1.0 with an unknown section length
+	pw.buf = block(pw.buf[:0], blockSectionHeader, func(b []byte) []byte {
+		b = binary.LittleEndian.AppendUint32(b, byteOrderMagic)
+		b = binary.LittleEndian.AppendUint16(b, 1)
+		b = binary.LittleEndian.AppendUint16(b, 0)
+		return binary.LittleEndian.AppendUint64(b, ^uint64(0))
+	})
+	// A single interface carrying raw ip with microsecond timestamps
+	pw.buf = block(pw.buf, blockInterface, func(b []byte) []byte {
+		b = binary.LittleEndian.AppendUint16(b, linkTypeRaw)
+		b = binary.LittleEndian.AppendUint16(b, 0)
+		return binary.LittleEndian.AppendUint32(b, 0)
+	})
+	if _, err := w.Write(pw.buf); err != nil {
+		return nil, err
+	}
+	return &pw, nil
+}
+
+func (pw *Writer) WritePacket(t time.Time, dir Direction, packet []byte) error {
+	pw.lock.Lock()
+	defer pw.lock.Unlock()
+	ts := uint64(t.UnixMicro())
+	pw.buf = block(pw.buf[:0], blockEnhancedPacket, func(b []byte) []byte {
+		b = binary.LittleEndian.AppendUint32(b, 0)
+		b = binary.LittleEndian.AppendUint32(b, uint32(ts>>32))
+		b = binary.LittleEndian.AppendUint32(b, uint32(ts))
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(packet)))
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(packet)))
+		b = pad(append(b, packet...))
+		b = binary.LittleEndian.AppendUint16(b, optionEnhancedPacketFl)
+		b = binary.LittleEndian.AppendUint16(b, 4)
+		b = binary.LittleEndian.AppendUint32(b, uint32(dir))
+		b = binary.LittleEndian.AppendUint16(b, optionEndOfOptions)
+		return binary.LittleEndian.AppendUint16(b, 0)
+	})
+	_, err := pw.w.Write(pw.buf)
+	return err
+}
+
+// block frames the body written by fn with the block type and total length at both ends
+func block(b []byte, typ uint32, fn func([]byte) []byte) []byte {
+	start := len(b)
+	b = binary.LittleEndian.AppendUint32(b, typ)
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	b = fn(b)
+	size := uint32(len(b) - start + 4)
+	binary.LittleEndian.PutUint32(b[start+4:], size)
+	return binary.LittleEndian.AppendUint32(b, size)
+}
+
+func pad(b []byte) []byte {
+	for len(b)%4 != 0 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+// PacketWriter matches ipv4.PacketWriter and the netstack.
+type PacketWriter interface {
+	Write(bufs [][]byte, offset int) (int, error)
+}
+
+type tap struct {
+	PacketWriter
+	capture *Writer
+	dir     Direction
+}
+
+// Tap records every packet written to next before passing it on.
+func Tap(next PacketWriter, capture *Writer, dir Direction) PacketWriter {
+	if capture == nil {
+		return next
+	}
+	return &tap{PacketWriter: next, capture: capture, dir: dir}
+}
+
+func (t *tap) Write(bufs [][]byte, offset int) (int, error) {
+	now := time.Now()
+	for _, b := range bufs {
+		if len(b) > offset {
+			_ = t.capture.WritePacket(now, t.dir, b[offset:])
+		}
+	}
+	return t.PacketWriter.Write(bufs, offset)
+}
@@ -0,0 +1,100 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+type testBlock struct {
+	typ  uint32
+	body []byte
+}
+
+// readBlocks splits a pcapng stream, checking the total length at both ends of every block
+func readBlocks(t *testing.T, b []byte) (blocks []testBlock) {
+	for len(b) > 0 {
+		require.GreaterOrEqual(t, len(b), 12)
+		size := binary.LittleEndian.Uint32(b[4:])
+		require.Zero(t, size%4, "blocks are padded to 4 bytes")
+		require.LessOrEqual(t, int(size), len(b))
+		require.Equal(t, size, binary.LittleEndian.Uint32(b[size-4:]), "trailing length")
+		blocks = append(blocks, testBlock{typ: binary.LittleEndian.Uint32(b), body: b[8 : size-4]})
+		b = b[size:]
+	}
+	return blocks
+}
+
+type packetSink [][]byte
+
+func (ps *packetSink) Write(bufs [][]byte, offset int) (int, error) {
+	for _, b := range bufs {
+		*ps = append(*ps, b[offset:])
+	}
+	return len(bufs), nil
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	require.NoError(t, err)
+
+	var sink packetSink
+	in := Tap(&sink, w, Inbound)
+	out := Tap(&sink, w, Outbound)
+	assert.Same(t, &sink, Tap(&sink, nil, Inbound))
+	now := time.UnixMicro(1_700_000_000_123_456)
+	_, err = in.Write([][]byte{[]byte("xx12345"), []byte("xx")}, 2)
+	require.NoError(t, err)
+	require.NoError(t, w.WritePacket(now, Outbound, []byte("abcd")))
+	_, err = out.Write([][]byte{[]byte("ab")}, 0)
+	require.NoError(t, err)
+	assert.Equal(t, [][]byte{[]byte("12345"), {}, []byte("ab")}, [][]byte(sink))
+
+	blocks := readBlocks(t, buf.Bytes())
+	require.Len(t, blocks, 5)
+
+	shb := blocks[0]
+	assert.Equal(t, uint32(blockSectionHeader), shb.typ)
+	assert.Equal(t, uint32(byteOrderMagic), binary.LittleEndian.Uint32(shb.body))
+	assert.Equal(t, uint16(1), binary.LittleEndian.Uint16(shb.body[4:]))
+	assert.Equal(t, uint16(0), binary.LittleEndian.Uint16(shb.body[6:]))
+
+	idb := blocks[1]
+	assert.Equal(t, uint32(blockInterface), idb.typ)
+	assert.Equal(t, uint16(linkTypeRaw), binary.LittleEndian.Uint16(idb.body))
+
+	for i, tt := range []struct {
+		packet string
+		dir    Direction
+	}{
+		{packet: "12345", dir: Inbound},
+		{packet: "abcd", dir: Outbound},
+		{packet: "ab", dir: Outbound},
+	} {
+		epb := blocks[i+2]
+		assert.Equal(t, uint32(blockEnhancedPacket), epb.typ)
+		b := epb.body
+		assert.Zero(t, binary.LittleEndian.Uint32(b), "interface id")
+		captured, original := binary.LittleEndian.Uint32(b[12:]), binary.LittleEndian.Uint32(b[16:])
+		assert.Equal(t, uint32(len(tt.packet)), captured)
+		assert.Equal(t, captured, original)
+
+		padded := (len(tt.packet) + 3) &^ 3
+		assert.Equal(t, tt.packet, string(b[20:20+len(tt.packet)]))
+		assert.Equal(t, make([]byte, padded-len(tt.packet)), b[20+len(tt.packet):20+padded], "padding")
+
+		opts := b[20+padded:]
+		require.Len(t, opts, 12)
+		assert.Equal(t, uint16(optionEnhancedPacketFl), binary.LittleEndian.Uint16(opts))
+		assert.Equal(t, uint16(4), binary.LittleEndian.Uint16(opts[2:]))
+		assert.Equal(t, uint32(tt.dir), binary.LittleEndian.Uint32(opts[4:]))
+		assert.Equal(t, make([]byte, 4), opts[8:], "end of options")
+	}
+
+	ts := blocks[3].body
+	assert.Equal(t, uint64(now.UnixMicro()), uint64(binary.LittleEndian.Uint32(ts[4:]))<<32|uint64(binary.LittleEndian.Uint32(ts[8:])))
+}
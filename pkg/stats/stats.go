@@ -0,0 +1,69 @@
+package stats
+
+import (
+	"github.com/beetbasket/runner/pkg/ipv4"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+type Tunnel struct {
+	Packets ipv4.Stats `json:"packets"`
+	// Connections and listeners open through Dial, Listen and Accept. Sockets the netstack is still closing are not counted
+	Conns     int64 `json:"conns"`
+	Listeners int64 `json:"listeners"`
+	// Inputs waiting to be written to the child on the parent, unread stdin bytes on the child
+	QueueDepth int64 `json:"queue_depth"`
+}
+
+// Counters tracks the connections and listeners handed out by Conn and Listener.
+// It only sees what is wrapped, the netstack itself is not inspected.
+type Counters struct {
+	conns, listeners atomic.Int64
+}
+
+func (c *Counters) Conns() int64     { return c.conns.Load() }
+func (c *Counters) Listeners() int64 { return c.listeners.Load() }
+
+func (c *Counters) Conn(conn net.Conn, err error) (net.Conn, error) {
+	if err != nil {
+		return nil, err
+	}
+	c.conns.Add(1)
+	return &trackedConn{Conn: conn, done: func() { c.conns.Add(-1) }}, nil
+}
+
+func (c *Counters) Listener(ln net.Listener, err error) (net.Listener, error) {
+	if err != nil {
+		return nil, err
+	}
+	c.listeners.Add(1)
+	return &trackedListener{Listener: ln, counters: c, done: func() { c.listeners.Add(-1) }}, nil
+}
+
+type trackedConn struct {
+	net.Conn
+	once sync.Once
+	done func()
+}
+
+func (tc *trackedConn) Close() error {
+	tc.once.Do(tc.done)
+	return tc.Conn.Close()
+}
+
+type trackedListener struct {
+	net.Listener
+	counters *Counters
+	once     sync.Once
+	done     func()
+}
+
+func (tl *trackedListener) Accept() (net.Conn, error) {
+	return tl.counters.Conn(tl.Listener.Accept())
+}
+
+func (tl *trackedListener) Close() error {
+	tl.once.Do(tl.done)
+	return tl.Listener.Close()
+}
@@ -0,0 +1,43 @@
+package stats
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"testing"
+)
+
+func TestCounters(t *testing.T) {
+	var c Counters
+	_, err := c.Conn(nil, errors.New("dial failed"))
+	assert.Error(t, err)
+	_, err = c.Listener(nil, errors.New("listen failed"))
+	assert.Error(t, err)
+	assert.Zero(t, c.Conns())
+	assert.Zero(t, c.Listeners())
+
+	ln, err := c.Listener(net.Listen("tcp", "127.0.0.1:0"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), c.Listeners())
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ln.Accept()
+		accepted <- conn
+	}()
+	dialed, err := c.Conn(net.Dial("tcp", ln.Addr().String()))
+	require.NoError(t, err)
+	server := <-accepted
+	require.NotNil(t, server)
+	assert.Equal(t, int64(2), c.Conns(), "dialed and accepted")
+
+	for _, conn := range []net.Conn{dialed, server} {
+		require.NoError(t, conn.Close())
+		_ = conn.Close()
+	}
+	assert.Zero(t, c.Conns(), "closing twice only counts once")
+	require.NoError(t, ln.Close())
+	_ = ln.Close()
+	assert.Zero(t, c.Listeners())
+}
@@ -0,0 +1,184 @@
+package stdionet
+
+import (
+	"bytes"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	defaultFlushInterval = 50 * time.Millisecond
+	// An unterminated line is written after this many intervals even if packets never stop
+	maxPartialIntervals = 4
+	maxHeldPackets      = 256
+)
+
+// lineWriter shares a stream between the application and packet lines, packets are only written at line boundaries.
+// An unterminated line is written once no packet has been written for the flush interval, or once it is
+// maxPartialIntervals old. Packets that come after it wait up to the interval for the application to end the line,
+// then a newline ends it for them. A newline also ends it early once maxHeldPackets are waiting.
+type lineWriter struct {
+	lock         sync.Mutex
+	w            io.Writer
+	interval     time.Duration
+	timer        *time.Timer
+	holdTimer    *time.Timer
+	partial      []byte
+	partialSince time.Time
+	midLine      bool
+	held         [][]byte
+	lastPacket   time.Time
+}
+
+func newLineWriter(w io.Writer, interval time.Duration) *lineWriter {
+	return &lineWriter{w: w, interval: interval}
+}
+
+func (lw *lineWriter) Write(b []byte) (int, error) {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+
+	i := bytes.LastIndexByte(b, '\n')
+	if i < 0 {
+		if lw.midLine || lw.interval <= 0 {
+			lw.midLine = true
+			return lw.w.Write(b)
+		}
+		if len(lw.partial) == 0 {
+			lw.schedule()
+		}
+		lw.partial = append(lw.partial, b...)
+		return len(b), nil
+	}
+
+	if _, err := lw.w.Write(append(lw.partial, b[:i+1]...)); err != nil {
+		return 0, err
+	}
+	lw.partial, lw.midLine = lw.partial[:0], false
+	if err := lw.writeHeld(); err != nil {
+		return i + 1, err
+	}
+	if rest := b[i+1:]; len(rest) > 0 {
+		if lw.interval <= 0 {
+			lw.midLine = true
+			n, err := lw.w.Write(rest)
+			return i + 1 + n, err
+		}
+		lw.schedule()
+		lw.partial = append(lw.partial, rest...)
+	}
+	return len(b), nil
+}
+
+func (lw *lineWriter) writeHeld() error {
+	for len(lw.held) > 0 {
+		if _, err := lw.w.Write(lw.held[0]); err != nil {
+			return err
+		}
+		lw.held = lw.held[1:]
+	}
+	lw.held = nil
+	return nil
+}
+
+func (lw *lineWriter) schedule() {
+	lw.partialSince = time.Now()
+	lw.scheduleIn(lw.interval)
+}
+
+func (lw *lineWriter) scheduleIn(d time.Duration) {
+	if lw.timer == nil {
+		lw.timer = time.AfterFunc(d, lw.flushIdle)
+	} else {
+		lw.timer.Reset(d)
+	}
+}
+
+// flushIdle writes an unterminated line unless packets are still being written, so a prompt can't block the tunnel.
+// Steady packets only put it off until it is maxPartialIntervals old.
+func (lw *lineWriter) flushIdle() {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+	if len(lw.partial) == 0 {
+		return
+	}
+	deadline := maxPartialIntervals*lw.interval - time.Since(lw.partialSince)
+	if wait := min(lw.interval-time.Since(lw.lastPacket), deadline); wait > 0 {
+		lw.scheduleIn(wait)
+		return
+	}
+	_ = lw.flush()
+}
+
+// Flush writes an unterminated line now.
+func (lw *lineWriter) Flush() error {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+	return lw.flush()
+}
+
+func (lw *lineWriter) flush() error {
+	if len(lw.partial) == 0 {
+		return nil
+	}
+	lw.midLine = true
+	_, err := lw.w.Write(lw.partial)
+	lw.partial = lw.partial[:0]
+	return err
+}
+
+// endLine ends a line the application left unterminated so held packets can go out
+func (lw *lineWriter) endLine() {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+	if lw.midLine && len(lw.held) > 0 {
+		_ = lw.breakLine()
+	}
+}
+
+func (lw *lineWriter) breakLine() error {
+	if _, err := lw.w.Write([]byte{'\n'}); err != nil {
+		return err
+	}
+	lw.midLine = false
+	return lw.writeHeld()
+}
+
+func (lw *lineWriter) writePacket(line []byte) error {
+	lw.lock.Lock()
+	defer lw.lock.Unlock()
+	lw.lastPacket = time.Now()
+	if lw.midLine && len(lw.held) < maxHeldPackets {
+		if len(lw.held) == 0 {
+			wait := lw.interval
+			if wait <= 0 {
+				wait = defaultFlushInterval
+			}
+			if lw.holdTimer == nil {
+				lw.holdTimer = time.AfterFunc(wait, lw.endLine)
+			} else {
+				lw.holdTimer.Reset(wait)
+			}
+		}
+		lw.held = append(lw.held, slices.Clone(line))
+		return nil
+	} else if lw.midLine {
+		// Too many packets are waiting, end the line now rather than dropping them
+		if err := lw.breakLine(); err != nil {
+			return err
+		}
+	}
+	_, err := lw.w.Write(line)
+	return err
+}
+
+type packetLines lineWriter
+
+func (pl *packetLines) Write(b []byte) (int, error) {
+	if err := (*lineWriter)(pl).writePacket(b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
@@ -0,0 +1,161 @@
+package stdionet
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/beetbasket/runner/pkg/matcher"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLineWriter(t *testing.T) {
+	t.Run("packet waits for line end", func(t *testing.T) {
+		var wire bytes.Buffer
+		lw := newLineWriter(&wire, time.Hour)
+		_, _ = lw.Write([]byte("abc"))
+		_, _ = (*packetLines)(lw).Write([]byte("pfx 1\n"))
+		_, _ = lw.Write([]byte("def\nghi"))
+		assert.Equal(t, "pfx 1\nabcdef\n", wire.String())
+		require.NoError(t, lw.Flush())
+		assert.Equal(t, "pfx 1\nabcdef\nghi", wire.String())
+	})
+
+	t.Run("flushed line holds packets", func(t *testing.T) {
+		var wire lockedBuffer
+		lw := newLineWriter(&wire, time.Hour)
+		_, _ = lw.Write([]byte("prompt> "))
+		require.NoError(t, lw.Flush())
+		_, _ = (*packetLines)(lw).Write([]byte("pfx 1\n"))
+		_, _ = lw.Write([]byte("more"))
+		assert.Equal(t, "prompt> more", wire.String())
+		_, _ = lw.Write([]byte("\n"))
+		assert.Equal(t, "prompt> more\npfx 1\n", wire.String())
+	})
+
+	t.Run("held packets end the line", func(t *testing.T) {
+		var wire lockedBuffer
+		lw := newLineWriter(&wire, 5*time.Millisecond)
+		_, _ = lw.Write([]byte("prompt> "))
+		require.Eventually(t, func() bool { return wire.String() == "prompt> " }, time.Second, time.Millisecond)
+		_, _ = (*packetLines)(lw).Write([]byte("pfx 1\n"))
+		_, _ = (*packetLines)(lw).Write([]byte("pfx 2\n"))
+		assert.Equal(t, "prompt> ", wire.String())
+		require.Eventually(t, func() bool { return wire.String() == "prompt> \npfx 1\npfx 2\n" }, time.Second, time.Millisecond)
+		_, _ = lw.Write([]byte("answer\n"))
+		assert.Equal(t, "prompt> \npfx 1\npfx 2\nanswer\n", wire.String())
+	})
+
+	t.Run("packets defer flush", func(t *testing.T) {
+		var wire lockedBuffer
+		lw := newLineWriter(&wire, 50*time.Millisecond)
+		_, _ = lw.Write([]byte("prompt> "))
+		for range 10 {
+			_, _ = (*packetLines)(lw).Write([]byte("pfx 1\n"))
+			time.Sleep(5 * time.Millisecond)
+		}
+		assert.Equal(t, strings.Repeat("pfx 1\n", 10), wire.String())
+		require.Eventually(t, func() bool { return strings.HasSuffix(wire.String(), "prompt> ") }, time.Second, time.Millisecond)
+	})
+
+	t.Run("steady packets only defer flush so long", func(t *testing.T) {
+		var wire lockedBuffer
+		lw := newLineWriter(&wire, 10*time.Millisecond)
+		_, _ = lw.Write([]byte("prompt> "))
+		stop := make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for {
+				select {
+				case <-stop:
+					return
+				case <-time.After(time.Millisecond):
+					_, _ = (*packetLines)(lw).Write([]byte("pfx 1\n"))
+				}
+			}
+		}()
+		require.Eventually(t, func() bool { return strings.Contains(wire.String(), "prompt> ") }, time.Second, time.Millisecond)
+		close(stop)
+		<-done
+	})
+
+	t.Run("full hold ends the line", func(t *testing.T) {
+		var wire lockedBuffer
+		lw := newLineWriter(&wire, time.Hour)
+		_, _ = lw.Write([]byte("prompt> "))
+		require.NoError(t, lw.Flush())
+		for i := range maxHeldPackets + 1 {
+			_, err := fmt.Fprintf((*packetLines)(lw), "pfx %d\n", i)
+			require.NoError(t, err)
+		}
+		var want strings.Builder
+		want.WriteString("prompt> \n")
+		for i := range maxHeldPackets + 1 {
+			fmt.Fprintf(&want, "pfx %d\n", i)
+		}
+		assert.Equal(t, want.String(), wire.String(), "no packet is dropped")
+	})
+
+	t.Run("unbuffered", func(t *testing.T) {
+		var wire bytes.Buffer
+		lw := newLineWriter(&wire, 0)
+		_, _ = lw.Write([]byte("a\nb"))
+		_, _ = (*packetLines)(lw).Write([]byte("pfx 1\n"))
+		_, _ = lw.Write([]byte("c\n"))
+		assert.Equal(t, "a\nbc\npfx 1\n", wire.String())
+	})
+
+	t.Run("concurrent", func(t *testing.T) {
+		var wire lockedBuffer
+		lw := newLineWriter(&wire, time.Millisecond)
+		var wg sync.WaitGroup
+		wg.Add(2)
+		var want strings.Builder
+		for i := range 500 {
+			fmt.Fprintf(&want, "line %d\n", i)
+		}
+		go func() {
+			defer wg.Done()
+			for i := range 500 {
+				_, _ = fmt.Fprintf(lw, "line")
+				_, _ = fmt.Fprintf(lw, " %d", i)
+				_, _ = fmt.Fprintf(lw, "\n")
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := range 200 {
+				_, _ = fmt.Fprintf((*packetLines)(lw), "pfx %d\n", i)
+			}
+		}()
+		wg.Wait()
+
+		var out, special bytes.Buffer
+		mm := matcher.New("pfx", &out, &special)
+		_, _ = mm.Write([]byte(wire.String()))
+		mm.Flush()
+		assert.Equal(t, want.String(), out.String())
+		assert.Equal(t, 200, strings.Count(special.String(), "\n"))
+	})
+}
+
+type lockedBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (lb *lockedBuffer) Write(b []byte) (int, error) {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+	return lb.buf.Write(b)
+}
+
+func (lb *lockedBuffer) String() string {
+	lb.lock.Lock()
+	defer lb.lock.Unlock()
+	return lb.buf.String()
+}
@@ -0,0 +1,146 @@
+package stdionet
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/beetbasket/runner/pkg/ipv4"
+	"github.com/beetbasket/runner/pkg/trace"
+	"github.com/point-c/wg"
+	"github.com/trymoose/errors"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+var ErrNoParent = errors.New("PACKET_PREFIX is not set, not running under a runner parent")
+
+type Option func(*options)
+
+type options struct {
+	env    *Env
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	exit   func(code int)
+	flush  time.Duration
+}
+
+// WithEnv uses ev instead of reading the handshake from the environment.
+func WithEnv(ev Env) Option {
+	return func(o *options) { o.env = &ev }
+}
+
+// WithStdio replaces the process' stdin, stdout and stderr.
+func WithStdio(stdin io.Reader, stdout, stderr io.Writer) Option {
+	return func(o *options) {
+		o.stdin, o.stdout, o.stderr = stdin, stdout, stderr
+	}
+}
+
+// WithExit is called by Exit, the default closes the StdioNet and exits the process.
+func WithExit(fn func(code int)) Option {
+	return func(o *options) { o.exit = fn }
+}
+
+// WithFlushInterval sets how long an unterminated line on stdout is held back, 0 writes it immediately.
+// It is held for longer while packets are being written. Packets can't be written inside a line, so a packet that
+// comes after an unterminated line waits up to d for the application to end it, then a newline is inserted before it.
+// Children that print prompts or progress bars while using the tunnel can avoid that newline when the parent
+// moves packets off stdout with WithPacketOutput(StreamFD).
+func WithFlushInterval(d time.Duration) Option {
+	return func(o *options) { o.flush = d }
+}
+
+// Open starts the child side of the tunnel, it runs until ctx is done or Close is called.
+func Open(ctx context.Context, opts ...Option) (*StdioNet, error) {
+	o := options{
+		stdin:  os.Stdin,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+		flush:  defaultFlushInterval,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.env == nil {
+		ev, err := ParseEnv(os.Getenv)
+		if err != nil {
+			return nil, err
+		}
+		o.env = &ev
+	}
+
+	key, err := base64.StdEncoding.DecodeString(o.env.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	ns, err := wg.NewDefaultNetstack()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	sn := StdioNet{
+		ns:      ns,
+		address: ipv4.GenerateRandomIPv4(),
+		env:     *o.env,
+		session: ipv4.NewSession(key, ipv4.Child),
+		stdin:   newPipe(),
+		stdout:  newLineWriter(o.stdout, o.flush),
+		stderr:  newLineWriter(o.stderr, o.flush),
+		opts:    o,
+		cancel:  cancel,
+	}
+	if sn.opts.exit == nil {
+		sn.opts.exit = func(code int) {
+			_ = sn.Close()
+			os.Exit(code)
+		}
+	}
+	sn.packets = sn.packetOutput()
+
+	context.AfterFunc(ctx, func() { sn.stdin.CloseWithError(ctx.Err()) })
+	go sn.writePackets(ctx)
+	go sn.sortStdin(ctx)
+	return &sn, nil
+}
+
+// Close stops the tunnel and closes the netstack, it is safe to call more than once.
+func (sn *StdioNet) Close() error {
+	sn.close.Do(func() {
+		sn.cancel()
+		sn.closeErr = errors.Join(sn.stdout.Flush(), sn.stderr.Flush(), sn.ns.Close())
+	})
+	return sn.closeErr
+}
+
+// ParseEnv reads the handshake the parent passes in the environment.
+func ParseEnv(getenv func(string) string) (ev Env, err error) {
+	ev.Prefix = getenv("PACKET_PREFIX")
+	ev.Key = getenv("PACKET_KEY")
+	ev.TraceParent = getenv(trace.EnvTraceParent)
+	if ev.Prefix == "" {
+		return Env{}, ErrNoParent
+	}
+	if ev.Address = net.ParseIP(getenv("PARENT_ADDRESS")).To4(); ev.Address == nil {
+		return Env{}, fmt.Errorf("invalid PARENT_ADDRESS %q", getenv("PARENT_ADDRESS"))
+	}
+	for _, fd := range []struct {
+		name string
+		dst  *int
+	}{
+		{"PACKET_INPUT_FD", &ev.InputFD},
+		{"PACKET_OUTPUT_FD", &ev.OutputFD},
+	} {
+		if v := getenv(fd.name); v != "" {
+			if *fd.dst, err = strconv.Atoi(v); err != nil {
+				return Env{}, fmt.Errorf("invalid %s: %w", fd.name, err)
+			}
+		}
+	}
+	return ev, nil
+}
@@ -0,0 +1,97 @@
+package stdionet
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Pipe is the child's stdin, Read blocks until data arrives, the parent closes stdin, or the read deadline passes.
+type Pipe struct {
+	lock     sync.Mutex
+	buf      bytes.Buffer
+	err      error
+	deadline time.Time
+	notify   chan struct{}
+}
+
+func newPipe() *Pipe {
+	return &Pipe{notify: make(chan struct{})}
+}
+
+func (p *Pipe) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	for {
+		p.lock.Lock()
+		if p.buf.Len() > 0 {
+			defer p.lock.Unlock()
+			return p.buf.Read(b)
+		} else if p.err != nil {
+			defer p.lock.Unlock()
+			return 0, p.err
+		} else if !p.deadline.IsZero() && !time.Now().Before(p.deadline) {
+			p.lock.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		}
+		notify, deadline := p.notify, p.deadline
+		p.lock.Unlock()
+
+		if deadline.IsZero() {
+			<-notify
+			continue
+		}
+		timer := time.NewTimer(time.Until(deadline))
+		select {
+		case <-notify:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// SetReadDeadline makes pending and future reads fail with os.ErrDeadlineExceeded after t, the zero time disables it.
+func (p *Pipe) SetReadDeadline(t time.Time) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.deadline = t
+	p.wake()
+	return nil
+}
+
+func (p *Pipe) Len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.buf.Len()
+}
+
+func (p *Pipe) Write(b []byte) (int, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.err != nil {
+		return 0, io.ErrClosedPipe
+	}
+	defer p.wake()
+	return p.buf.Write(b)
+}
+
+// CloseWithError makes reads return err once the buffered data is read, nil means io.EOF.
+func (p *Pipe) CloseWithError(err error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.err != nil {
+		return
+	} else if err == nil {
+		err = io.EOF
+	}
+	p.err = err
+	p.wake()
+}
+
+func (p *Pipe) wake() {
+	close(p.notify)
+	p.notify = make(chan struct{})
+}
@@ -1,20 +1,24 @@
 package stdionet
 
 import (
-	"bytes"
 	"context"
 	"github.com/beetbasket/program/pkg/env"
 	"github.com/beetbasket/program/pkg/log"
 	"github.com/beetbasket/runner/pkg/ipv4"
 	"github.com/beetbasket/runner/pkg/matcher"
-	"github.com/beetbasket/runner/pkg/message/input"
+	"github.com/beetbasket/runner/pkg/pcap"
+	"github.com/beetbasket/runner/pkg/stats"
+	"github.com/beetbasket/runner/pkg/trace"
 	"github.com/point-c/wg"
+	"github.com/trymoose/errors"
 	"go.uber.org/fx"
 	"io"
 	"log/slog"
 	"net"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 func New() fx.Option {
@@ -30,16 +34,30 @@ func New() fx.Option {
 }
 
 type Env struct {
-	Prefix  string `env:"PACKET_PREFIX" description:"Line prefix of network packets"`
-	Address net.IP `env:"PARENT_ADDRESS" description:"Parent's ip address'" parser:"ipv4"`
+	Prefix   string `env:"PACKET_PREFIX" description:"Line prefix of network packets"`
+	Address  net.IP `env:"PARENT_ADDRESS" description:"Parent's ip address'" parser:"ipv4"`
+	InputFD  int    `env:"PACKET_INPUT_FD" description:"File descriptor packets are read from"`
+	OutputFD int    `env:"PACKET_OUTPUT_FD" description:"File descriptor packets are written to"`
+	Key      string `env:"PACKET_KEY" description:"Base64 key authenticating packet lines"`
+	// TraceParent is optional, it is set when the parent traces the child
+	TraceParent string `env:"TRACEPARENT" description:"W3C traceparent of the parent's span"`
 }
 
 type StdioNet struct {
 	env      Env
 	ns       *wg.Netstack
 	address  net.IP
-	stdin    lockedBuf
-	shutdown fx.Shutdowner
+	stdin    *Pipe
+	stdout   *lineWriter
+	stderr   *lineWriter
+	packets  io.Writer
+	session  *ipv4.Session
+	counters stats.Counters
+	capture  atomic.Pointer[pcap.Writer]
+	opts     options
+	cancel   func()
+	close    sync.Once
+	closeErr error
 }
 
 func newStdionet(
@@ -48,46 +66,61 @@ func newStdionet(
 	ev Env,
 	ctx context.Context,
 ) (*StdioNet, error) {
-	ns, err := wg.NewDefaultNetstack()
+	sn, err := Open(ctx, WithEnv(ev), WithExit(func(code int) {
+		if err := shutdown.Shutdown(fx.ExitCode(code)); err != nil {
+			slog.Error("failed to shutdown", log.Err(err))
+		}
+	}))
 	if err != nil {
 		return nil, err
 	}
-	lf.Append(fx.StopHook(func() error {
-		return ns.Close()
-	}))
+	lf.Append(fx.StopHook(sn.Close))
+	return sn, nil
+}
 
-	sn := StdioNet{
-		ns:       ns,
-		address:  ipv4.GenerateRandomIPv4(),
-		env:      ev,
-		shutdown: shutdown,
+func (sn *StdioNet) sortStdin(ctx context.Context) {
+	var err error
+	if sn.env.InputFD == 0 {
+		err = sn.sortInput(ctx, sn.opts.stdin, sn.env.Prefix, sn.stdin)
+	} else {
+		go func() {
+			if err := sn.sortInput(ctx, os.NewFile(uintptr(sn.env.InputFD), "packets"), sn.env.Prefix, io.Discard); !errors.Is(err, io.EOF) && ctx.Err() == nil {
+				slog.Error("failed to read packets", slog.Any("error", err))
+			}
+		}()
+		err = sn.sortInput(ctx, sn.opts.stdin, "", sn.stdin)
 	}
 
-	ctx, cancel := context.WithCancel(ctx)
-	lf.Append(fx.StartStopHook(func() {
-		go sn.writePackets(ctx)
-	}, cancel))
-	lf.Append(fx.StartStopHook(func() {
-		go sn.sortStdin(ctx)
-	}, cancel))
-	return &sn, nil
+	if errors.Is(err, io.EOF) {
+		err = nil
+	} else if ctx.Err() == nil {
+		slog.Error("failed to read from stdin", slog.Any("error", err))
+	}
+	sn.stdin.CloseWithError(err)
 }
 
-func (sn *StdioNet) sortStdin(ctx context.Context) {
+func (sn *StdioNet) sortInput(ctx context.Context, r io.Reader, prefix string, out io.Writer) error {
 	var buf [1000]byte
-	mm := matcher.New(sn.env.Prefix)
+	mm := matcher.New(prefix, out, sn.session.Decoder((*inboundPackets)(sn)))
+	defer mm.Flush()
 	for ctx.Err() == nil {
-		n, err := os.Stdin.Read(buf[:])
+		n, err := r.Read(buf[:])
+		_, _ = mm.Write(buf[:n])
 		if err != nil {
-			slog.Error("failed to read from stdin", slog.Any("error", err))
-			return
+			return err
 		}
-		_, _ = mm.Write(buf[:n])
+	}
+	return ctx.Err()
+}
 
-		_, _ = sn.stdin.Write(mm.ReadOut())
-		if b := mm.ReadSpecial(); len(b) > 0 {
-			ipv4.DecodePackets(sn.ns, b)
-		}
+func (sn *StdioNet) packetOutput() io.Writer {
+	switch sn.env.OutputFD {
+	case 0, 1:
+		return (*packetLines)(sn.stdout)
+	case 2:
+		return (*packetLines)(sn.stderr)
+	default:
+		return os.NewFile(uintptr(sn.env.OutputFD), "packets")
 	}
 }
 
@@ -103,38 +136,90 @@ func (sn *StdioNet) writePackets(ctx context.Context) {
 			continue
 		}
 		for i, b := range buf {
-			data := input.NewPacketInput(sn.env.Prefix, b[:size[i]]).Input()
-			if _, err := sn.Stdout().Write(data); err != nil {
-				slog.Error("failed to write packet to stdout", slog.Any("error", err))
+			sn.capturePacket(pcap.Outbound, b[:size[i]])
+			data := sn.session.Seal(sn.env.Prefix, b[:size[i]]).Input()
+			if _, err := sn.packets.Write(data); err != nil {
+				slog.Error("failed to write packet", slog.Any("error", err))
 				return
 			}
 		}
 	}
 }
 
+// Capture writes every packet through the tunnel from now on to w as pcapng, inbound packets are the ones from the parent.
+func (sn *StdioNet) Capture(w io.Writer) error {
+	pw, err := pcap.NewWriter(w)
+	if err != nil {
+		return err
+	}
+	sn.capture.Store(pw)
+	return nil
+}
+
+func (sn *StdioNet) capturePacket(dir pcap.Direction, packet []byte) {
+	if pw := sn.capture.Load(); pw != nil {
+		_ = pw.WritePacket(time.Now(), dir, packet)
+	}
+}
+
+type inboundPackets StdioNet
+
+func (ip *inboundPackets) Write(bufs [][]byte, offset int) (int, error) {
+	packets := make([][]byte, 0, len(bufs))
+	for _, b := range bufs {
+		if len(b) <= offset {
+			// The parent closed stdin, the empty frame is not a packet
+			ip.stdin.CloseWithError(nil)
+			continue
+		}
+		(*StdioNet)(ip).capturePacket(pcap.Inbound, b[offset:])
+		packets = append(packets, b)
+	}
+	if len(packets) == 0 {
+		return len(bufs), nil
+	}
+	n, err := ip.ns.Write(packets, offset)
+	return n + len(bufs) - len(packets), err
+}
+
+// Stdout is line buffered so packet lines never split the application's lines.
 func (sn *StdioNet) Stdout() io.Writer {
-	return os.Stdout
+	return sn.stdout
 }
 
 func (sn *StdioNet) Stderr() io.Writer {
-	return os.Stderr
+	return sn.stderr
 }
 
-func (sn *StdioNet) Stdin() io.Reader {
-	return &sn.stdin
+func (sn *StdioNet) Stdin() *Pipe {
+	return sn.stdin
 }
 
 func (sn *StdioNet) Exit(code ...int) {
-	var opts []fx.ShutdownOption
 	if len(code) > 0 {
-		opts = append(opts, fx.ExitCode(code[0]))
+		sn.opts.exit(code[0])
+	} else {
+		sn.opts.exit(0)
 	}
+}
 
-	if err := sn.shutdown.Shutdown(opts...); err != nil {
-		slog.Error("failed to shutdown", log.Err(err))
+func (sn *StdioNet) Stats() stats.Tunnel {
+	return stats.Tunnel{
+		Packets:    sn.session.Stats(),
+		Conns:      sn.counters.Conns(),
+		Listeners:  sn.counters.Listeners(),
+		QueueDepth: int64(sn.stdin.Len()),
 	}
 }
 
+// TraceContext continues the trace the parent passed in TRACEPARENT, if there is one.
+func (sn *StdioNet) TraceContext(ctx context.Context) context.Context {
+	if sc, err := trace.ParseTraceParent(sn.env.TraceParent); err == nil {
+		return trace.ContextWithSpanContext(ctx, sc)
+	}
+	return ctx
+}
+
 func (sn *StdioNet) Address() net.IP {
 	return sn.address
 }
@@ -151,29 +236,12 @@ func (sn *StdioNet) ParentAddrTCP(port uint16) *net.TCPAddr {
 }
 
 func (sn *StdioNet) Dial(ctx context.Context, addr *net.TCPAddr) (net.Conn, error) {
-	return sn.ns.Net().Dialer(sn.address, 0).DialTCP(ctx, addr)
+	return sn.counters.Conn(sn.ns.Net().Dialer(sn.address, 0).DialTCP(ctx, addr))
 }
 
 func (sn *StdioNet) Listen(port uint16) (net.Listener, error) {
-	return sn.ns.Net().Listen(&net.TCPAddr{
+	return sn.counters.Listener(sn.ns.Net().Listen(&net.TCPAddr{
 		IP:   sn.address,
 		Port: int(port),
-	})
-}
-
-type lockedBuf struct {
-	buf  bytes.Buffer
-	lock sync.RWMutex
-}
-
-func (lb *lockedBuf) Read(b []byte) (int, error) {
-	lb.lock.Lock()
-	defer lb.lock.Unlock()
-	return lb.buf.Read(b)
-}
-
-func (lb *lockedBuf) Write(b []byte) (int, error) {
-	lb.lock.Lock()
-	defer lb.lock.Unlock()
-	return lb.buf.Write(b)
+	}))
 }
@@ -0,0 +1,166 @@
+package stdionet
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"github.com/beetbasket/runner/pkg/ipv4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseEnv(t *testing.T) {
+	vars := map[string]string{
+		"PACKET_PREFIX":    "pfx",
+		"PARENT_ADDRESS":   "1.2.3.4",
+		"PACKET_OUTPUT_FD": "2",
+		"PACKET_KEY":       "a2V5",
+		"TRACEPARENT":      "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+	}
+	ev, err := ParseEnv(func(k string) string { return vars[k] })
+	require.NoError(t, err)
+	assert.Equal(t, Env{Prefix: "pfx", Address: net.IPv4(1, 2, 3, 4).To4(), OutputFD: 2, Key: "a2V5", TraceParent: vars["TRACEPARENT"]}, ev)
+
+	vars["PACKET_INPUT_FD"] = "x"
+	_, err = ParseEnv(func(k string) string { return vars[k] })
+	assert.Error(t, err)
+
+	_, err = ParseEnv(func(string) string { return "" })
+	assert.ErrorIs(t, err, ErrNoParent)
+}
+
+func TestOpen(t *testing.T) {
+	key := ipv4.GenerateKey()
+	parent := ipv4.NewSession(key, ipv4.Parent)
+
+	stdin, writeStdin := io.Pipe()
+	exited := make(chan int, 1)
+	sn, err := Open(context.Background(),
+		WithEnv(Env{Prefix: "pfx", Address: net.IPv4(1, 2, 3, 4).To4(), Key: base64.StdEncoding.EncodeToString(key)}),
+		WithStdio(stdin, io.Discard, io.Discard),
+		WithExit(func(code int) { exited <- code }),
+	)
+	require.NoError(t, err)
+	defer sn.Close()
+
+	_, err = writeStdin.Write([]byte("hello\n"))
+	require.NoError(t, err)
+	_, err = writeStdin.Write(parent.Seal("pfx", []byte{0x45, 0, 0, 20}).Input())
+	require.NoError(t, err)
+	_, err = writeStdin.Write([]byte("pfx not a packet\n"))
+	require.NoError(t, err)
+
+	require.EventuallyWithT(t, func(t *assert.CollectT) {
+		st := sn.Stats()
+		assert.Equal(t, uint64(1), st.Packets.Accepted)
+		assert.Equal(t, uint64(1), st.Packets.Malformed)
+	}, time.Second, time.Millisecond)
+	require.NoError(t, writeStdin.Close())
+	b, err := io.ReadAll(sn.Stdin())
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", string(b))
+
+	sn.Exit(3)
+	assert.Equal(t, 3, <-exited)
+	sn.Exit()
+	assert.Equal(t, 0, <-exited)
+
+	require.NoError(t, sn.Close())
+	require.NoError(t, sn.Close())
+}
+
+func TestStdin(t *testing.T) {
+	stdin, writeStdin := io.Pipe()
+	sn, err := Open(context.Background(),
+		WithEnv(Env{Prefix: "pfx", Address: net.IPv4(1, 2, 3, 4).To4()}),
+		WithStdio(stdin, io.Discard, io.Discard),
+		WithExit(func(int) {}),
+	)
+	require.NoError(t, err)
+	defer sn.Close()
+
+	lines := make(chan string)
+	done := make(chan error, 1)
+	go func() {
+		sc := bufio.NewScanner(sn.Stdin())
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+		done <- sc.Err()
+	}()
+
+	for _, line := range []string{"one", "two"} {
+		_, err = writeStdin.Write([]byte(line + "\n"))
+		require.NoError(t, err)
+		assert.Equal(t, line, <-lines)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("scanner stopped early: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	_, err = writeStdin.Write([]byte("last"))
+	require.NoError(t, err)
+	require.NoError(t, writeStdin.Close())
+	assert.Equal(t, "last", <-lines)
+	assert.NoError(t, <-done)
+}
+
+func TestPipe(t *testing.T) {
+	t.Run("deadline", func(t *testing.T) {
+		p := newPipe()
+		require.NoError(t, p.SetReadDeadline(time.Now().Add(10*time.Millisecond)))
+		_, err := p.Read(make([]byte, 1))
+		assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+		require.NoError(t, p.SetReadDeadline(time.Time{}))
+		_, _ = p.Write([]byte("x"))
+		b := make([]byte, 2)
+		n, err := p.Read(b)
+		require.NoError(t, err)
+		assert.Equal(t, "x", string(b[:n]))
+	})
+
+	t.Run("pending read sees new deadline", func(t *testing.T) {
+		p := newPipe()
+		errs := make(chan error, 1)
+		go func() {
+			_, err := p.Read(make([]byte, 1))
+			errs <- err
+		}()
+		time.Sleep(5 * time.Millisecond)
+		require.NoError(t, p.SetReadDeadline(time.Now()))
+		assert.ErrorIs(t, <-errs, os.ErrDeadlineExceeded)
+	})
+
+	t.Run("close drains first", func(t *testing.T) {
+		p := newPipe()
+		_, _ = p.Write([]byte("abc"))
+		p.CloseWithError(nil)
+		b, err := io.ReadAll(p)
+		require.NoError(t, err)
+		assert.Equal(t, "abc", string(b))
+		_, err = p.Write([]byte("d"))
+		assert.ErrorIs(t, err, io.ErrClosedPipe)
+	})
+
+	t.Run("context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		stdin, _ := io.Pipe()
+		sn, err := Open(ctx,
+			WithEnv(Env{Prefix: "pfx", Address: net.IPv4(1, 2, 3, 4).To4()}),
+			WithStdio(stdin, io.Discard, io.Discard),
+		)
+		require.NoError(t, err)
+		defer sn.Close()
+		cancel()
+		_, err = sn.Stdin().Read(make([]byte, 1))
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
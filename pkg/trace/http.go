@@ -0,0 +1,52 @@
+package trace
+
+import (
+	"net/http"
+	"strconv"
+)
+
+const headerTraceParent = "traceparent"
+
+type transport struct {
+	tracer *Tracer
+	base   http.RoundTripper
+}
+
+// Transport wraps base with a client span for every request and propagates it in the traceparent header.
+func Transport(t *Tracer, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{tracer: t, base: base}
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx, span := t.tracer.Start(r.Context(), "HTTP "+r.Method)
+	defer span.End()
+	span.SetAttribute("http.method", r.Method)
+	span.SetAttribute("http.url", r.URL.String())
+
+	r = r.Clone(ctx)
+	r.Header.Set(headerTraceParent, span.Context().TraceParent())
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		span.SetError(err)
+		return nil, err
+	}
+	span.SetAttribute("http.status_code", strconv.Itoa(resp.StatusCode))
+	return resp, nil
+}
+
+// Middleware starts a server span for every request, continuing the trace from the traceparent header.
+func Middleware(t *Tracer, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if sc, err := ParseTraceParent(r.Header.Get(headerTraceParent)); err == nil {
+			ctx = ContextWithSpanContext(ctx, sc)
+		}
+		ctx, span := t.Start(ctx, r.Method+" "+r.URL.Path)
+		defer span.End()
+		span.SetAttribute("http.method", r.Method)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
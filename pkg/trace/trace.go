@@ -0,0 +1,194 @@
+package trace
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/trymoose/errors"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const EnvTraceParent = "TRACEPARENT"
+
+var ErrInvalidTraceParent = errors.New("invalid traceparent")
+
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+func (id SpanID) String() string  { return hex.EncodeToString(id[:]) }
+
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// TraceParent formats sc as a W3C traceparent header.
+func (sc SpanContext) TraceParent() string {
+	flags := 0
+	if sc.Sampled {
+		flags = 1
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, flags)
+}
+
+func ParseTraceParent(s string) (sc SpanContext, _ error) {
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) != 4 || parts[0] != "00" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return sc, ErrInvalidTraceParent
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+		return sc, ErrInvalidTraceParent
+	} else if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+		return sc, ErrInvalidTraceParent
+	} else if _, err := hex.Decode(flags[:], []byte(parts[3])); err != nil {
+		return sc, ErrInvalidTraceParent
+	} else if !sc.IsValid() {
+		return sc, ErrInvalidTraceParent
+	}
+	sc.Sampled = flags[0]&1 == 1
+	return sc, nil
+}
+
+type spanContextKey struct{}
+
+func ContextWithSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, sc)
+}
+
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	sc, ok := ctx.Value(spanContextKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+type Event struct {
+	Name string    `json:"name"`
+	Time time.Time `json:"time"`
+}
+
+// SpanData is the finished form of a span handed to an Exporter.
+type SpanData struct {
+	Name       string            `json:"name"`
+	Context    SpanContext       `json:"context"`
+	Parent     SpanID            `json:"parent"`
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Events     []Event           `json:"events,omitempty"`
+	Err        string            `json:"error,omitempty"`
+}
+
+type Exporter interface {
+	Export(SpanData)
+}
+
+type Tracer struct {
+	exporter Exporter
+}
+
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+// Start begins a span that is a child of the span context in ctx, or a new trace if there is none.
+func (t *Tracer) Start(ctx context.Context, name string) (context.Context, *Span) {
+	s := Span{
+		tracer: t,
+		data: SpanData{
+			Name:       name,
+			Start:      time.Now(),
+			Attributes: make(map[string]string),
+		},
+	}
+	if parent, ok := SpanContextFromContext(ctx); ok {
+		s.data.Context.TraceID, s.data.Parent, s.data.Context.Sampled = parent.TraceID, parent.SpanID, parent.Sampled
+	} else {
+		_, _ = rand.Read(s.data.Context.TraceID[:])
+		s.data.Context.Sampled = true
+	}
+	_, _ = rand.Read(s.data.Context.SpanID[:])
+	return ContextWithSpanContext(ctx, s.data.Context), &s
+}
+
+type Span struct {
+	tracer *Tracer
+	lock   sync.Mutex
+	data   SpanData
+	ended  bool
+}
+
+func (s *Span) Context() SpanContext {
+	return s.data.Context
+}
+
+func (s *Span) SetAttribute(key, value string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data.Attributes[key] = value
+}
+
+func (s *Span) AddEvent(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data.Events = append(s.data.Events, Event{Name: name, Time: time.Now()})
+}
+
+func (s *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.data.Err = err.Error()
+}
+
+// End exports the span, only the first call has any effect.
+func (s *Span) End() {
+	s.lock.Lock()
+	if s.ended {
+		s.lock.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	// Later SetAttribute and AddEvent calls must not change what the exporter sees
+	data := s.data
+	data.Attributes = maps.Clone(data.Attributes)
+	data.Events = slices.Clone(data.Events)
+	s.lock.Unlock()
+
+	if data.Context.Sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.Export(data)
+	}
+}
+
+// InMemoryExporter keeps every exported span, for tests.
+type InMemoryExporter struct {
+	lock  sync.Mutex
+	spans []SpanData
+}
+
+func (e *InMemoryExporter) Export(s SpanData) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.spans = append(e.spans, s)
+}
+
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
@@ -0,0 +1,81 @@
+package trace
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTraceParent(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		value string
+		err   error
+	}{
+		{name: "sampled", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{name: "not sampled", value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"},
+		{name: "bad version", value: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", err: ErrInvalidTraceParent},
+		{name: "zero trace", value: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", err: ErrInvalidTraceParent},
+		{name: "not hex", value: "00-4bf92f3577b34da6a3ce929d0e0e473z-00f067aa0ba902b7-01", err: ErrInvalidTraceParent},
+		{name: "short", value: "00-4bf92f35-00f067aa0ba902b7-01", err: ErrInvalidTraceParent},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, err := ParseTraceParent(tt.value)
+			assert.ErrorIs(t, err, tt.err)
+			if tt.err == nil {
+				assert.Equal(t, tt.value, sc.TraceParent())
+			}
+		})
+	}
+}
+
+func TestHTTPPropagation(t *testing.T) {
+	var exp InMemoryExporter
+	tracer := NewTracer(&exp)
+
+	srv := httptest.NewServer(Middleware(tracer, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})))
+	defer srv.Close()
+
+	ctx, root := tracer.Start(context.Background(), "root")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/foo", nil)
+	require.NoError(t, err)
+	resp, err := (&http.Client{Transport: Transport(tracer, nil)}).Do(req)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	root.End()
+	root.End()
+
+	spans := exp.Spans()
+	require.Len(t, spans, 3)
+	server, client, rootData := spans[0], spans[1], spans[2]
+	assert.Equal(t, "GET /foo", server.Name)
+	assert.Equal(t, "HTTP GET", client.Name)
+	assert.Equal(t, "root", rootData.Name)
+
+	assert.Equal(t, rootData.Context.TraceID, client.Context.TraceID)
+	assert.Equal(t, rootData.Context.TraceID, server.Context.TraceID)
+	assert.Equal(t, rootData.Context.SpanID, client.Parent)
+	assert.Equal(t, client.Context.SpanID, server.Parent)
+	assert.Equal(t, "418", client.Attributes["http.status_code"])
+}
+
+func TestSpanEndSnapshot(t *testing.T) {
+	var exp InMemoryExporter
+	_, span := NewTracer(&exp).Start(context.Background(), "span")
+	span.SetAttribute("a", "1")
+	span.AddEvent("first")
+	span.End()
+	span.SetAttribute("b", "2")
+	span.AddEvent("second")
+
+	spans := exp.Spans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, map[string]string{"a": "1"}, spans[0].Attributes)
+	require.Len(t, spans[0].Events, 1)
+	assert.Equal(t, "first", spans[0].Events[0].Name)
+}
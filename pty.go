@@ -0,0 +1,40 @@
+package runner
+
+import (
+	"github.com/beetbasket/runner/pkg/message/output"
+	"io"
+)
+
+type ptyOptions struct {
+	rows, cols uint16
+}
+
+// WithPTY runs the child on a pseudo-terminal. Its output is delivered raw as stdout,
+// and the packet tunnel moves to extra file descriptors as with StreamFD.
+func WithPTY(rows, cols uint16) Option {
+	return func(o *options) {
+		o.pty = &ptyOptions{rows: rows, cols: cols}
+	}
+}
+
+func (cmd *Cmd) initializePTY() (io.WriteCloser, error) {
+	master, tty, err := openPTY()
+	if err != nil {
+		return nil, err
+	}
+	cmd.pty = master
+	cmd.closeAfterStart = append(cmd.closeAfterStart, tty)
+	cmd.closeAfterWait = append(cmd.closeAfterWait, master)
+	if cmd.opts.pty.rows > 0 && cmd.opts.pty.cols > 0 {
+		if err := setWindowSize(master, cmd.opts.pty.rows, cmd.opts.pty.cols); err != nil {
+			return nil, err
+		}
+	}
+
+	cmd.cmd.Stdin, cmd.cmd.Stdout, cmd.cmd.Stderr = tty, tty, tty
+	setControllingTerminal(cmd.cmd)
+
+	stdout := newKindWriter[output.StdoutMessage](cmd, "")
+	cmd.readers = append(cmd.readers, func() { _, _ = io.Copy(stdout, master) })
+	return master, nil
+}
@@ -0,0 +1,69 @@
+package runner
+
+import (
+	"context"
+	"github.com/beetbasket/runner/pkg/message"
+	"github.com/beetbasket/runner/pkg/message/input"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPTY(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		script   string
+		input    []message.Input
+		closeIn  bool
+		contains string
+	}{
+		{
+			name:     "child sees a terminal",
+			script:   `[ -t 0 ] && [ -t 1 ] && [ -t 2 ] && echo isatty`,
+			contains: "isatty",
+		},
+		{
+			name:     "initial window size",
+			script:   `stty size`,
+			contains: "24 80",
+		},
+		{
+			name:     "resize",
+			script:   `read line; stty size`,
+			input:    []message.Input{input.NewWindowSizeInput(30, 100), input.NewInputln("go")},
+			contains: "30 100",
+		},
+		{
+			name:     "close input sends ^D",
+			script:   `cat >/dev/null; echo eof`,
+			input:    []message.Input{input.NewInputln("some input")},
+			closeIn:  true,
+			contains: "eof",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			cmd, err := New(ctx, NewCommandArgs("sh", []string{"-c", tt.script}), WithPTY(24, 80))
+			require.NoError(t, err)
+			defer cmd.Close()
+			stdout := cmd.StdoutReader()
+			cmd.Start()
+			for _, in := range tt.input {
+				cmd.Input(in)
+			}
+			if tt.closeIn {
+				cmd.CloseInput()
+			}
+
+			b, err := io.ReadAll(stdout)
+			require.NoError(t, err)
+			assert.Contains(t, strings.ReplaceAll(string(b), "\r\n", "\n"), tt.contains+"\n")
+			_, err = cmd.Result(ctx)
+			assert.NoError(t, err)
+		})
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !linux
+
+package runner
+
+import (
+	"github.com/trymoose/errors"
+	"os"
+	"os/exec"
+)
+
+var ErrPTYUnsupported = errors.New("pty mode is only supported on linux")
+
+func openPTY() (_, _ *os.File, _ error) {
+	return nil, nil, ErrPTYUnsupported
+}
+
+func setWindowSize(*os.File, uint16, uint16) error {
+	return ErrPTYUnsupported
+}
+
+func setControllingTerminal(*exec.Cmd) {}
@@ -0,0 +1,94 @@
+package runner
+
+import (
+	"github.com/beetbasket/runner/pkg/message"
+	"github.com/beetbasket/runner/pkg/message/output"
+	"sync"
+)
+
+type replayOptions struct {
+	messages int
+	bytes    int
+	all      bool
+}
+
+// WithReplay keeps the last messages sent on Output for subscribers that join late, 0 means no limit on that axis.
+// WithReplay(0, 0) keeps every message like WithFullReplay, and negative limits are treated as 0.
+// Bytes are counted from stdio data, so exit and start messages are always kept within the message limit.
+func WithReplay(messages, bytes int) Option {
+	return func(o *options) {
+		o.replay = replayOptions{messages: max(messages, 0), bytes: max(bytes, 0)}
+		o.replay.all = messages <= 0 && bytes <= 0
+	}
+}
+
+// WithFullReplay keeps every message so any subscriber sees the output from the beginning.
+func WithFullReplay() Option {
+	return WithReplay(0, 0)
+}
+
+func (ro replayOptions) enabled() bool {
+	return ro.all || ro.messages > 0 || ro.bytes > 0
+}
+
+// replay is the history of Output. Every message is numbered so a subscriber can skip the live
+// messages already in the history it copied, without holding the lock while they are sent.
+type replay struct {
+	// emitLock keeps messages sent in the order they were numbered
+	emitLock sync.Mutex
+	lock     sync.Mutex
+	msgs     []message.Message
+	size     int
+	seq      uint64
+}
+
+type sequenced struct {
+	seq uint64
+	msg message.Message
+}
+
+func (cmd *Cmd) emit(msg message.Message) {
+	cmd.replay.emitLock.Lock()
+	defer cmd.replay.emitLock.Unlock()
+	cmd.out.Next(cmd.record(msg))
+}
+
+func (cmd *Cmd) complete(msgs ...message.Message) {
+	cmd.replay.emitLock.Lock()
+	defer cmd.replay.emitLock.Unlock()
+	seqs := make([]sequenced, len(msgs))
+	for i, msg := range msgs {
+		seqs[i] = cmd.record(msg)
+	}
+	cmd.out.Complete(seqs...)
+}
+
+func (cmd *Cmd) record(msg message.Message) sequenced {
+	ro, r := cmd.opts.replay, &cmd.replay
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.seq++
+	if !ro.enabled() {
+		return sequenced{seq: r.seq, msg: msg}
+	}
+	r.msgs = append(r.msgs, msg)
+	r.size += replaySize(msg)
+	for len(r.msgs) > 1 && ((ro.messages > 0 && len(r.msgs) > ro.messages) || (ro.bytes > 0 && r.size > ro.bytes)) {
+		r.size -= replaySize(r.msgs[0])
+		r.msgs[0] = nil
+		r.msgs = r.msgs[1:]
+	}
+	return sequenced{seq: r.seq, msg: msg}
+}
+
+func replaySize(msg message.Message) int {
+	switch msg := msg.(type) {
+	case output.StdoutMessage:
+		return len(msg.Data)
+	case output.StderrMessage:
+		return len(msg.Data)
+	case output.StdinMessage:
+		return len(msg.Data)
+	}
+	return 0
+}
@@ -0,0 +1,109 @@
+package runner
+
+import (
+	"github.com/beetbasket/runner/pkg/message"
+	"github.com/beetbasket/runner/pkg/message/output"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestReplayRecord(t *testing.T) {
+	// Messages are written as "start", "exit", "stdout:data" or "stderr:data"
+	newMsg := func(s string) message.Message {
+		switch kind, data, _ := strings.Cut(s, ":"); kind {
+		case "start":
+			return output.NewStartMessage()
+		case "exit":
+			return output.NewExitMessage(0, "")
+		case "stderr":
+			return output.NewStdioMessage[output.StderrMessage](data)
+		default:
+			return output.NewStdioMessage[output.StdoutMessage](data)
+		}
+	}
+	describe := func(msg message.Message) string {
+		switch msg := msg.(type) {
+		case output.StdoutMessage:
+			return "stdout:" + string(msg.Data)
+		case output.StderrMessage:
+			return "stderr:" + string(msg.Data)
+		}
+		return output.KindOf(msg)
+	}
+	for _, tt := range []struct {
+		name   string
+		opt    Option
+		input  []string
+		output []string
+		size   int
+	}{
+		{
+			name:  "disabled",
+			opt:   func(*options) {},
+			input: []string{"start", "stdout:a", "exit"},
+		},
+		{
+			name:   "full",
+			opt:    WithFullReplay(),
+			input:  []string{"start", "stdout:a", "stderr:bc", "exit"},
+			output: []string{"start", "stdout:a", "stderr:bc", "exit"},
+			size:   3,
+		},
+		{
+			name:   "message limit",
+			opt:    WithReplay(2, 0),
+			input:  []string{"start", "stdout:a", "stderr:bc", "exit"},
+			output: []string{"stderr:bc", "exit"},
+			size:   2,
+		},
+		{
+			name:   "byte limit",
+			opt:    WithReplay(0, 3),
+			input:  []string{"start", "stdout:ab", "stdout:c", "stdout:de"},
+			output: []string{"stdout:c", "stdout:de"},
+			size:   3,
+		},
+		{
+			name:   "byte limit only trims until under",
+			opt:    WithReplay(0, 2),
+			input:  []string{"stdout:ab", "start", "stdout:c"},
+			output: []string{"start", "stdout:c"},
+			size:   1,
+		},
+		{
+			name:   "keeps a message larger than the byte limit",
+			opt:    WithReplay(0, 2),
+			input:  []string{"stdout:a", "stdout:bcd"},
+			output: []string{"stdout:bcd"},
+			size:   3,
+		},
+		{
+			name:   "both limits",
+			opt:    WithReplay(3, 4),
+			input:  []string{"stdout:ab", "stdout:cd", "exit", "stdout:e"},
+			output: []string{"stdout:cd", "exit", "stdout:e"},
+			size:   3,
+		},
+		{
+			name:   "negative is no limit",
+			opt:    WithReplay(-1, 2),
+			input:  []string{"start", "stdout:a", "stdout:b", "stdout:c"},
+			output: []string{"stdout:b", "stdout:c"},
+			size:   2,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := Cmd{opts: newOptions([]Option{tt.opt})}
+			for _, s := range tt.input {
+				cmd.record(newMsg(s))
+			}
+			var got []string
+			for _, msg := range cmd.replay.msgs {
+				got = append(got, describe(msg))
+			}
+			assert.Equal(t, tt.output, got)
+			assert.Equal(t, tt.size, cmd.replay.size)
+		})
+	}
+}
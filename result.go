@@ -0,0 +1,52 @@
+package runner
+
+import (
+	"context"
+	"github.com/beetbasket/runner/pkg/message/output"
+	"github.com/trymoose/errors"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+type ExitStatus struct {
+	Code int
+	// Signal that killed the child, nil if it exited on its own
+	Signal   os.Signal
+	Duration time.Duration
+	Reason   output.ExitReason
+}
+
+func newExitStatus(err error, d time.Duration) ExitStatus {
+	status := ExitStatus{Duration: d}
+	if exit := new(exec.ExitError); errors.As(err, &exit) {
+		status.Code = exit.ExitCode()
+		if ws, ok := exit.Sys().(interface {
+			Signaled() bool
+			Signal() syscall.Signal
+		}); ok && ws.Signaled() {
+			status.Signal = ws.Signal()
+		}
+	} else if err != nil {
+		status.Code = -1
+	}
+	return status
+}
+
+// Result waits for the child to exit. The error is nil if it exited with code 0, an ExitError if it didn't,
+// or ErrNotStarted if it was closed before Start.
+func (cmd *Cmd) Result(ctx context.Context) (ExitStatus, error) {
+	select {
+	case <-ctx.Done():
+		return ExitStatus{}, ctx.Err()
+	case <-cmd.wait:
+	}
+
+	if !cmd.ran {
+		return cmd.status, ErrNotStarted
+	} else if cmd.status.Code != 0 {
+		return cmd.status, &ExitError{Code: cmd.status.Code, Err: cmd.waitErr}
+	}
+	return cmd.status, cmd.waitErr
+}
@@ -0,0 +1,31 @@
+package runner
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestNewExitStatus(t *testing.T) {
+	run := func(script string) error { return exec.Command("sh", "-c", script).Run() }
+	for _, tt := range []struct {
+		name   string
+		err    error
+		code   int
+		signal os.Signal
+	}{
+		{name: "success"},
+		{name: "exit code", err: run("exit 3"), code: 3},
+		{name: "signal", err: run("kill -TERM $$"), code: -1, signal: syscall.SIGTERM},
+		{name: "not an exit", err: errors.New("start failed"), code: -1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			status := newExitStatus(tt.err, time.Second)
+			assert.Equal(t, ExitStatus{Code: tt.code, Signal: tt.signal, Duration: time.Second}, status)
+		})
+	}
+}
@@ -1,36 +1,144 @@
 package runner
 
 import (
-	"bytes"
 	"context"
 	"fmt"
+	"github.com/trymoose/errors"
+	"io"
 	"os/exec"
 )
 
 type Output struct {
 	Stdout, Stderr []byte
-	Code           int
-	Err            error
+	// Number of bytes dropped from the middle of each stream when a limit is set
+	StdoutTruncated, StderrTruncated int64
+	Code                             int
+	Err                              error
 }
 
-func Run(ctx context.Context, cmd CommandArgsEnv) (out Output) {
+// ExitError is returned when the command ran but exited with a non-zero code.
+type ExitError struct {
+	Code   int
+	Stderr []byte
+	Err    error
+}
+
+const exitErrorStderr = 4096
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit code(%d) stderr(%q)", e.Code, e.Stderr)
+}
+
+func (e *ExitError) Unwrap() error {
+	return e.Err
+}
+
+type RunOption func(*runOptions)
+
+type runOptions struct {
+	limit          int
+	stdin          io.Reader
+	stdout, stderr io.Writer
+}
+
+// WithOutputLimit caps the buffered stdout and stderr to n bytes each, keeping the head and tail.
+func WithOutputLimit(n int) RunOption {
+	return func(o *runOptions) { o.limit = n }
+}
+
+func WithStdin(r io.Reader) RunOption {
+	return func(o *runOptions) { o.stdin = r }
+}
+
+// WithStdout receives all of stdout as it is written, regardless of the limit.
+func WithStdout(w io.Writer) RunOption {
+	return func(o *runOptions) { o.stdout = w }
+}
+
+// WithStderr receives all of stderr as it is written, regardless of the limit.
+func WithStderr(w io.Writer) RunOption {
+	return func(o *runOptions) { o.stderr = w }
+}
+
+func Run(ctx context.Context, cmd CommandArgsEnv, opts ...RunOption) (out Output) {
+	var o runOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	c := exec.CommandContext(ctx, cmd.Command(), cmd.Args()...)
 	c.Env = cmd.Environment()
-	var stdout, stderr bytes.Buffer
-	c.Stdout, c.Stderr = &stdout, &stderr
+	c.Stdin = o.stdin
+	stdout, stderr := headTail{limit: o.limit}, headTail{limit: o.limit}
+	c.Stdout, c.Stderr = tee(&stdout, o.stdout), tee(&stderr, o.stderr)
 	err := c.Run()
-	out.Stdout = stdout.Bytes()
-	out.Stderr = stderr.Bytes()
+
+	out.Stdout, out.StdoutTruncated = stdout.Bytes(), stdout.dropped
+	out.Stderr, out.StderrTruncated = stderr.Bytes(), stderr.dropped
 	out.Code = c.ProcessState.ExitCode()
-	out.Err = fmt.Errorf("code(%d) stderr(%q) error(%v)", out.Code, out.Stderr, err)
+	if exit := new(exec.ExitError); errors.As(err, &exit) {
+		out.Err = newExitError(out.Code, out.Stderr, err)
+	} else if err != nil {
+		out.Err = err
+	}
 	return out
 }
 
+func newExitError(code int, stderr []byte, err error) *ExitError {
+	ht := headTail{limit: exitErrorStderr}
+	_, _ = ht.Write(stderr)
+	return &ExitError{Code: code, Stderr: ht.Bytes(), Err: err}
+}
+
 func (out *Output) Error() error {
 	if out.Err != nil {
 		return out.Err
 	} else if out.Code != 0 {
-		return fmt.Errorf("exit code(%d)", out.Code)
+		return newExitError(out.Code, out.Stderr, nil)
 	}
 	return nil
 }
+
+func tee(buf io.Writer, w io.Writer) io.Writer {
+	if w == nil {
+		return buf
+	}
+	return io.MultiWriter(buf, w)
+}
+
+// headTail keeps the first and last limit/2 bytes written to it, or everything when limit is not positive.
+type headTail struct {
+	limit      int
+	head, tail []byte
+	dropped    int64
+}
+
+func (ht *headTail) Write(b []byte) (int, error) {
+	n := len(b)
+	if ht.limit <= 0 {
+		ht.head = append(ht.head, b...)
+		return n, nil
+	}
+
+	headCap := ht.limit / 2
+	if room := headCap - len(ht.head); room > 0 {
+		k := min(room, len(b))
+		ht.head, b = append(ht.head, b[:k]...), b[k:]
+	}
+
+	tailCap := ht.limit - headCap
+	if over := len(b) - tailCap; over > 0 {
+		ht.dropped += int64(over + len(ht.tail))
+		ht.tail, b = ht.tail[:0], b[over:]
+	}
+	ht.tail = append(ht.tail, b...)
+	if over := len(ht.tail) - tailCap; over > 0 {
+		ht.dropped += int64(over)
+		ht.tail = append(ht.tail[:0], ht.tail[over:]...)
+	}
+	return n, nil
+}
+
+func (ht *headTail) Bytes() []byte {
+	return append(ht.head[:len(ht.head):len(ht.head)], ht.tail...)
+}
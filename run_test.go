@@ -0,0 +1,77 @@
+package runner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestHeadTail(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		limit   int
+		input   []string
+		output  string
+		dropped int64
+	}{
+		{
+			name:   "no limit",
+			input:  []string{"abc", "def"},
+			output: "abcdef",
+		},
+		{
+			name:   "under limit",
+			limit:  8,
+			input:  []string{"abc", "def"},
+			output: "abcdef",
+		},
+		{
+			name:    "odd limit gives tail the extra byte",
+			limit:   5,
+			input:   []string{"abcdefgh"},
+			output:  "abfgh",
+			dropped: 3,
+		},
+		{
+			name:    "limit of one",
+			limit:   1,
+			input:   []string{"abc", "d"},
+			output:  "d",
+			dropped: 3,
+		},
+		{
+			name:    "small writes",
+			limit:   4,
+			input:   []string{"ab", "cd", "ef", "gh"},
+			output:  "abgh",
+			dropped: 4,
+		},
+		{
+			name:    "write larger than tail",
+			limit:   4,
+			input:   []string{"ab", "cd", "efghij"},
+			output:  "abij",
+			dropped: 6,
+		},
+		{
+			name:    "write spans head and tail",
+			limit:   6,
+			input:   []string{"a", "bcdefghi"},
+			output:  "abcghi",
+			dropped: 3,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ht := headTail{limit: tt.limit}
+			var total int
+			for _, s := range tt.input {
+				n, err := ht.Write([]byte(s))
+				assert.NoError(t, err)
+				assert.Equal(t, len(s), n)
+				total += n
+			}
+			assert.Equal(t, tt.output, string(ht.Bytes()))
+			assert.Equal(t, tt.dropped, ht.dropped)
+			assert.Equal(t, int64(total-len(ht.Bytes())), ht.dropped)
+		})
+	}
+}
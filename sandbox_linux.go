@@ -0,0 +1,42 @@
+package runner
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// The child gets its own /proc for the new pid namespace and its own /sys for the new network namespace
+const sandboxScript = `mount --make-rprivate / || exit 125
+mount -t proc proc /proc && mount -t sysfs sysfs /sys || exit 125
+while [ "$1" != -- ]; do
+	mount --bind -- "$1" "$2" && mount -o remount,bind,ro -- "$2" || exit 125
+	shift 2
+done
+shift
+exec "$@"`
+
+func (sb *sandbox) apply(c *exec.Cmd) error {
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	attr := c.SysProcAttr
+	attr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID
+	attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+	attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+	attr.GidMappingsEnableSetgroups = false
+
+	// Mounts have to happen inside the new namespace, so a shell sets them up and then execs the real command
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		return err
+	}
+	args := []string{"sh", "-c", sandboxScript, "sh"}
+	for _, b := range sb.binds {
+		args = append(args, b.Source, b.Target)
+	}
+	args = append(args, "--", c.Path)
+	c.Args = append(args, c.Args[1:]...)
+	c.Path = sh
+	return nil
+}
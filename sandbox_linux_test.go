@@ -0,0 +1,49 @@
+package runner
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestSandboxApply(t *testing.T) {
+	if err := exec.Command("unshare", "--user", "--map-root-user", "--net", "--pid", "--fork", "--mount", "true").Run(); err != nil {
+		t.Skipf("user namespaces are unavailable: %v", err)
+	}
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "file"), []byte("bound"), 0o644))
+	for _, tt := range []struct {
+		name   string
+		binds  []Bind
+		script string
+		output string
+	}{
+		{
+			name:   "fresh proc",
+			script: "echo $$; cat /proc/1/comm",
+			output: "1\nsh\n",
+		},
+		{
+			name:   "fresh sysfs",
+			script: "ls /sys/class/net",
+			output: "lo\n",
+		},
+		{
+			name:   "read-only bind",
+			binds:  []Bind{{Source: dir, Target: "/mnt"}},
+			script: "cat /mnt/file; touch /mnt/file 2>/dev/null || echo ro",
+			output: "boundro\n",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			c := exec.Command("sh", "-c", tt.script)
+			require.NoError(t, (&sandbox{binds: tt.binds}).apply(c))
+			out, err := c.Output()
+			require.NoError(t, err)
+			assert.Equal(t, tt.output, string(out))
+		})
+	}
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package runner
+
+import (
+	"github.com/trymoose/errors"
+	"os/exec"
+)
+
+var ErrSandboxUnsupported = errors.New("sandboxing is only supported on linux")
+
+func (sb *sandbox) apply(*exec.Cmd) error {
+	return ErrSandboxUnsupported
+}
@@ -0,0 +1,40 @@
+package runner
+
+import (
+	"github.com/beetbasket/runner/pkg/message/output"
+	"github.com/beetbasket/runner/pkg/stats"
+	"time"
+)
+
+// WithStatsInterval emits a StatsMessage on Output every d while the child runs.
+func WithStatsInterval(d time.Duration) Option {
+	return func(o *options) { o.statsInterval = d }
+}
+
+func (cmd *Cmd) Stats() stats.Tunnel {
+	return stats.Tunnel{
+		Packets:    cmd.session.Stats(),
+		Conns:      cmd.counters.Conns(),
+		Listeners:  cmd.counters.Listeners(),
+		QueueDepth: cmd.queued.Load(),
+	}
+}
+
+func (cmd *Cmd) reportStats() {
+	if cmd.opts.statsInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(cmd.opts.statsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cmd.ctx.Done():
+			return
+		case <-cmd.wait:
+			return
+		case <-ticker.C:
+			cmd.emit(output.NewStatsMessage(cmd.Stats()))
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package runner
+
+import (
+	"context"
+	"github.com/beetbasket/runner/pkg/message/input"
+	"github.com/beetbasket/runner/pkg/message/output"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cmd, err := New(ctx, NewCommandArgs("sleep", []string{"0.2"}), WithStatsInterval(20*time.Millisecond))
+	require.NoError(t, err)
+	defer cmd.Close()
+
+	ln, err := cmd.Listen(8080)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), cmd.Stats().Listeners)
+
+	msgs := cmd.Output(ctx)
+	cmd.Start()
+	var reports []output.StatsMessage
+	for msg := range msgs {
+		if msg, ok := msg.(output.StatsMessage); ok {
+			reports = append(reports, msg)
+		}
+	}
+	require.NotEmpty(t, reports)
+	for _, msg := range reports {
+		assert.Equal(t, int64(1), msg.Stats.Listeners)
+		assert.Zero(t, msg.Stats.Conns)
+	}
+
+	require.NoError(t, ln.Close())
+	assert.Zero(t, cmd.Stats().Listeners)
+
+	// Input queued after Close is never written, and is not counted
+	require.NoError(t, cmd.Close())
+	require.Eventually(t, func() bool { return cmd.Stats().QueueDepth == 0 }, time.Second, time.Millisecond)
+	for range 3 {
+		cmd.Input(input.NewInputln("late"))
+	}
+	assert.Zero(t, cmd.Stats().QueueDepth)
+}
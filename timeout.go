@@ -0,0 +1,91 @@
+package runner
+
+import (
+	"github.com/beetbasket/runner/pkg/message/output"
+	"math"
+	"time"
+)
+
+type timeouts struct {
+	maxRuntime, idle, startup time.Duration
+}
+
+// WithMaxRuntime kills the child once it has been running for d.
+func WithMaxRuntime(d time.Duration) Option {
+	return func(o *options) { o.timeouts.maxRuntime = d }
+}
+
+// WithIdleTimeout kills the child after d without stdout, stderr or packets.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(o *options) { o.timeouts.idle = d }
+}
+
+// WithStartupTimeout kills the child if it produces no output within d of starting.
+func WithStartupTimeout(d time.Duration) Option {
+	return func(o *options) { o.timeouts.startup = d }
+}
+
+func (cmd *Cmd) touch() {
+	cmd.lastActivity.Store(time.Now().UnixNano())
+	cmd.active.Store(true)
+}
+
+func (cmd *Cmd) watchdog(start time.Time) {
+	if cmd.opts.timeouts == (timeouts{}) {
+		return
+	}
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-cmd.ctx.Done():
+			return
+		case now := <-timer.C:
+			last := time.Unix(0, cmd.lastActivity.Load())
+			reason, wait := cmd.opts.timeouts.check(now, start, last, cmd.active.Load())
+			if reason != "" {
+				cmd.kill(reason)
+				return
+			}
+			timer.Reset(wait)
+		}
+	}
+}
+
+func (t timeouts) check(now, start, last time.Time, active bool) (output.ExitReason, time.Duration) {
+	wait := time.Duration(math.MaxInt64)
+	for _, dl := range [...]struct {
+		limit  time.Duration
+		since  time.Time
+		skip   bool
+		reason output.ExitReason
+	}{
+		{limit: t.maxRuntime, since: start, reason: output.ExitMaxRuntime},
+		{limit: t.startup, since: start, skip: active, reason: output.ExitStartupTimeout},
+		{limit: t.idle, since: last, reason: output.ExitIdleTimeout},
+	} {
+		if dl.limit <= 0 || dl.skip {
+			continue
+		}
+		left := dl.limit - now.Sub(dl.since)
+		if left <= 0 {
+			return dl.reason, 0
+		}
+		wait = min(wait, left)
+	}
+	return "", wait
+}
+
+func (cmd *Cmd) kill(reason output.ExitReason) {
+	if cmd.reason.CompareAndSwap(nil, &reason) {
+		cmd.cancel()
+	}
+}
+
+func (cmd *Cmd) exitReason() output.ExitReason {
+	if r := cmd.reason.Load(); r != nil {
+		return *r
+	}
+	return ""
+}
@@ -0,0 +1,95 @@
+package runner
+
+import (
+	"github.com/beetbasket/runner/pkg/message/output"
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestTimeoutsCheck(t *testing.T) {
+	start := time.Unix(1000, 0)
+	for _, tt := range []struct {
+		name     string
+		timeouts timeouts
+		elapsed  time.Duration
+		idle     time.Duration
+		active   bool
+		reason   output.ExitReason
+		wait     time.Duration
+	}{
+		{
+			name: "none",
+			wait: math.MaxInt64,
+		},
+		{
+			name:     "max runtime left",
+			timeouts: timeouts{maxRuntime: 10 * time.Second},
+			elapsed:  4 * time.Second,
+			wait:     6 * time.Second,
+		},
+		{
+			name:     "max runtime hit exactly",
+			timeouts: timeouts{maxRuntime: 10 * time.Second},
+			elapsed:  10 * time.Second,
+			reason:   output.ExitMaxRuntime,
+		},
+		{
+			name:     "startup before max runtime",
+			timeouts: timeouts{maxRuntime: 10 * time.Second, startup: 2 * time.Second},
+			elapsed:  time.Second,
+			wait:     time.Second,
+		},
+		{
+			name:     "startup skipped once active",
+			timeouts: timeouts{maxRuntime: 10 * time.Second, startup: 2 * time.Second},
+			elapsed:  5 * time.Second,
+			active:   true,
+			wait:     5 * time.Second,
+		},
+		{
+			name:     "startup hit",
+			timeouts: timeouts{startup: 2 * time.Second, idle: 10 * time.Second},
+			elapsed:  3 * time.Second,
+			reason:   output.ExitStartupTimeout,
+		},
+		{
+			name:     "idle from last activity",
+			timeouts: timeouts{startup: 2 * time.Second, idle: 3 * time.Second},
+			elapsed:  5 * time.Second,
+			idle:     time.Second,
+			active:   true,
+			wait:     2 * time.Second,
+		},
+		{
+			name:     "idle hit",
+			timeouts: timeouts{idle: 3 * time.Second},
+			elapsed:  5 * time.Second,
+			idle:     4 * time.Second,
+			active:   true,
+			reason:   output.ExitIdleTimeout,
+		},
+		{
+			name:     "max runtime wins over idle",
+			timeouts: timeouts{maxRuntime: 5 * time.Second, idle: 3 * time.Second},
+			elapsed:  5 * time.Second,
+			idle:     4 * time.Second,
+			reason:   output.ExitMaxRuntime,
+		},
+		{
+			name:     "negative ignored",
+			timeouts: timeouts{maxRuntime: -time.Second, idle: 3 * time.Second},
+			elapsed:  5 * time.Second,
+			idle:     time.Second,
+			wait:     2 * time.Second,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			now := start.Add(tt.elapsed)
+			reason, wait := tt.timeouts.check(now, start, now.Add(-tt.idle), tt.active)
+			assert.Equal(t, tt.reason, reason)
+			assert.Equal(t, tt.wait, wait)
+		})
+	}
+}
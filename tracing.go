@@ -0,0 +1,96 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"github.com/beetbasket/runner/pkg/trace"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// WithTracer records a span from Start until the child exits and one for every Dial, lasting until the conn is closed.
+// The child gets the trace in TRACEPARENT.
+func WithTracer(t *trace.Tracer) Option {
+	return func(o *options) { o.tracer = t }
+}
+
+// cmdSpan covers the child from Start to exit, Dial can read it from other goroutines
+type cmdSpan struct {
+	span    atomic.Pointer[trace.Span]
+	once    sync.Once
+	command string
+}
+
+func (cmd *Cmd) startSpan() {
+	if cmd.opts.tracer == nil {
+		return
+	}
+	// cmd.ctx carries the span context of the caller of New, if there is one
+	_, span := cmd.opts.tracer.Start(cmd.ctx, "runner.cmd")
+	span.SetAttribute("cmd.command", cmd.span.command)
+	cmd.span.span.Store(span)
+}
+
+func (cmd *Cmd) traceEnv() []string {
+	span := cmd.span.span.Load()
+	if span == nil {
+		return nil
+	}
+	return []string{fmt.Sprintf("%s=%s", trace.EnvTraceParent, span.Context().TraceParent())}
+}
+
+func (cmd *Cmd) spanEvent(name string) {
+	if span := cmd.span.span.Load(); span != nil {
+		span.AddEvent(name)
+	}
+}
+
+func (cmd *Cmd) endSpan(code int, err error) {
+	span := cmd.span.span.Load()
+	if span == nil {
+		return
+	}
+	cmd.span.once.Do(func() {
+		span.SetAttribute("exit.code", strconv.Itoa(code))
+		if reason := cmd.exitReason(); reason != "" {
+			span.SetAttribute("exit.reason", string(reason))
+		}
+		span.SetError(err)
+		span.End()
+	})
+}
+
+// traceDial records a span from the dial until the connection is closed, so it covers the call made over it
+func (cmd *Cmd) traceDial(ctx context.Context, addr *net.TCPAddr, dial func(context.Context) (net.Conn, error)) (net.Conn, error) {
+	if cmd.opts.tracer == nil {
+		return dial(ctx)
+	}
+	if _, ok := trace.SpanContextFromContext(ctx); !ok {
+		if parent := cmd.span.span.Load(); parent != nil {
+			ctx = trace.ContextWithSpanContext(ctx, parent.Context())
+		}
+	}
+	ctx, span := cmd.opts.tracer.Start(ctx, "runner.dial")
+	span.SetAttribute("net.peer", addr.String())
+	conn, err := dial(ctx)
+	if err != nil {
+		span.SetError(err)
+		span.End()
+		return nil, err
+	}
+	return &tracedConn{Conn: conn, span: span}, nil
+}
+
+type tracedConn struct {
+	net.Conn
+	span *trace.Span
+}
+
+func (tc *tracedConn) Close() error {
+	err := tc.Conn.Close()
+	tc.span.SetError(err)
+	tc.span.End()
+	return err
+}
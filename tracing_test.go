@@ -0,0 +1,71 @@
+package runner
+
+import (
+	"context"
+	"github.com/beetbasket/runner/pkg/trace"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTraceParent(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	var exp trace.InMemoryExporter
+	parent, span := trace.NewTracer(&exp).Start(ctx, "test")
+	cmd, err := New(parent, NewCommandArgs("sh", []string{"-c", `echo "$TRACEPARENT"`}), WithTracer(trace.NewTracer(&exp)))
+	require.NoError(t, err)
+	defer cmd.Close()
+	stdout := cmd.StdoutReader()
+
+	time.Sleep(20 * time.Millisecond)
+	started := time.Now()
+	cmd.Start()
+	b, err := io.ReadAll(stdout)
+	require.NoError(t, err)
+	<-cmd.Wait()
+	span.End()
+
+	spans := exp.Spans()
+	require.Len(t, spans, 2)
+	cmdSpan := spans[0]
+	assert.Equal(t, "runner.cmd", cmdSpan.Name)
+	assert.Equal(t, span.Context().SpanID, cmdSpan.Parent)
+	assert.False(t, cmdSpan.Start.Before(started), "the span starts at Start, not New")
+	assert.Equal(t, "0", cmdSpan.Attributes["exit.code"])
+
+	sc, err := trace.ParseTraceParent(strings.TrimSpace(string(b)))
+	require.NoError(t, err)
+	assert.Equal(t, cmdSpan.Context, sc)
+}
+
+func TestTraceDial(t *testing.T) {
+	var exp trace.InMemoryExporter
+	cmd := Cmd{opts: newOptions([]Option{WithTracer(trace.NewTracer(&exp))})}
+	client, server := net.Pipe()
+	defer server.Close()
+	conn, err := cmd.traceDial(context.Background(), &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 80}, func(context.Context) (net.Conn, error) {
+		return client, nil
+	})
+	require.NoError(t, err)
+	assert.Empty(t, exp.Spans(), "the span lasts as long as the conn")
+	require.NoError(t, conn.Close())
+	_ = conn.Close()
+
+	spans := exp.Spans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "runner.dial", spans[0].Name)
+	assert.Equal(t, "10.0.0.1:80", spans[0].Attributes["net.peer"])
+
+	_, err = cmd.traceDial(context.Background(), &net.TCPAddr{}, func(context.Context) (net.Conn, error) {
+		return nil, io.ErrUnexpectedEOF
+	})
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	spans = exp.Spans()
+	require.Len(t, spans, 2)
+	assert.Equal(t, io.ErrUnexpectedEOF.Error(), spans[1].Err)
+}